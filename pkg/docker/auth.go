@@ -0,0 +1,217 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/go-errors/errors"
+	"github.com/spf13/viper"
+)
+
+const (
+	Gcloud = "gcloud"
+	Static = "static"
+	Env    = "env"
+	GHCR   = "ghcr"
+
+	gcloudUsername       = "oauth2accesstoken"
+	ghcrHost             = "ghcr.io"
+	artifactRegistryHost = "-docker.pkg.dev"
+)
+
+var (
+	ErrUnknownAuthHelper = errors.New("is not a known authentication helper")
+	ErrMissingCredential = errors.New("is required by the authentication helper")
+)
+
+// gcrHosts are the Container Registry hosts served by the gcloud helper.
+var gcrHosts = []string{"gcr.io", "eu.gcr.io", "us.gcr.io", "asia.gcr.io", "marketplace.gcr.io", "staging-k8s.gcr.io"}
+
+// AuthHelper provides credentials for container registries.
+type AuthHelper interface {
+	// Hosts lists the registries the helper serves when it is enabled through
+	// docker.authentication rather than for a single registry.
+	Hosts() []string
+	// AuthConfig returns the credentials for a registry host.
+	AuthConfig(host string) (types.AuthConfig, error)
+}
+
+// HostMatcher is implemented by helpers that also serve registries matching
+// a pattern, which are only known once an image reference points to them.
+type HostMatcher interface {
+	Matches(host string) bool
+}
+
+// AuthHelperFactory creates the helper configured by a registry entry.
+type AuthHelperFactory func(c *Client, r Registry) (AuthHelper, error)
+
+// Registry selects the authentication helper of a registry host, as listed
+// under docker.registries in ankor.yaml.
+type Registry struct {
+	Host   string `mapstructure:"host"`
+	Helper string `mapstructure:"helper"`
+	// Username and Token are used by the static helper.
+	Username string `mapstructure:"username"`
+	Token    string `mapstructure:"token"`
+	// UsernameEnv and TokenEnv name the variables read by the env helper.
+	UsernameEnv string `mapstructure:"usernameEnv"`
+	TokenEnv    string `mapstructure:"tokenEnv"`
+}
+
+func defaultAuthHelpers() map[string]AuthHelperFactory {
+	return map[string]AuthHelperFactory{
+		Gcloud:       newGcloudHelper,
+		Static:       newStaticHelper,
+		Env:          newEnvHelper,
+		GHCR:         newGHCRHelper,
+		DockerConfig: newDockerConfigHelper,
+	}
+}
+
+// WithAuthHelper registers an authentication helper under name, replacing
+// any built-in helper with the same name.
+func WithAuthHelper(name string, factory AuthHelperFactory) ClientOpt {
+	return func(c *Client) error {
+		if factory == nil {
+			return errors.New(fmt.Errorf("'%s' helper factory cannot be nil", name))
+		}
+		c.authHelpers[name] = factory
+		return nil
+	}
+}
+
+func (c *Client) newAuthHelper(r Registry) (AuthHelper, error) {
+	factory, ok := c.authHelpers[r.Helper]
+	if !ok {
+		return nil, errors.New(fmt.Errorf("'%s' %w", r.Helper, ErrUnknownAuthHelper))
+	}
+	return factory(c, r)
+}
+
+// registries reads the per registry helpers from the configuration.
+func registries() ([]Registry, error) {
+	var regs []Registry
+	if !viper.IsSet("docker.registries") {
+		return regs, nil
+	}
+	if err := viper.UnmarshalKey("docker.registries", &regs); err != nil {
+		return nil, errors.WrapPrefix(err, "invalid docker.registries configuration", 0)
+	}
+	for _, r := range regs {
+		if r.Host == "" {
+			return nil, errors.New(fmt.Errorf("'host' %w for every docker registry", ErrMissingOption))
+		}
+	}
+	return regs, nil
+}
+
+// gcloudHelper authenticates Google registries with the gcloud access token.
+type gcloudHelper struct {
+	c     *Client
+	token string
+}
+
+func newGcloudHelper(c *Client, _ Registry) (AuthHelper, error) {
+	return &gcloudHelper{c: c}, nil
+}
+
+// Hosts lists the Container Registry hosts together with the Artifact
+// Registry hosts of the regions configured in docker.gcloud.regions.
+func (h *gcloudHelper) Hosts() []string {
+	hosts := append([]string{}, gcrHosts...)
+	for _, region := range viper.GetStringSlice("docker.gcloud.regions") {
+		hosts = append(hosts, region+artifactRegistryHost)
+	}
+	return hosts
+}
+
+// Matches reports whether host is a Container Registry or Artifact Registry
+// host, e.g. europe-west1-docker.pkg.dev.
+func (h *gcloudHelper) Matches(host string) bool {
+	return host == "gcr.io" || strings.HasSuffix(host, ".gcr.io") || strings.HasSuffix(host, artifactRegistryHost)
+}
+
+func (h *gcloudHelper) AuthConfig(string) (types.AuthConfig, error) {
+	if h.token == "" {
+		authData, err := h.c.getGcloudAuthConfig()
+		if err != nil {
+			return types.AuthConfig{}, err
+		}
+		h.token = authData.Password
+	}
+	return types.AuthConfig{Username: gcloudUsername, Password: h.token}, nil
+}
+
+// staticHelper serves the username and token written in the configuration.
+type staticHelper struct {
+	username string
+	token    string
+}
+
+func newStaticHelper(_ *Client, r Registry) (AuthHelper, error) {
+	if r.Token == "" {
+		return nil, errors.New(fmt.Errorf("'token' %w '%s'", ErrMissingCredential, Static))
+	}
+	return &staticHelper{username: r.Username, token: r.Token}, nil
+}
+
+func (h *staticHelper) Hosts() []string {
+	return nil
+}
+
+func (h *staticHelper) AuthConfig(host string) (types.AuthConfig, error) {
+	return types.AuthConfig{Username: h.username, Password: h.token, ServerAddress: host}, nil
+}
+
+// envHelper reads the username and token from environment variables when
+// the credentials are needed.
+type envHelper struct {
+	username    string
+	usernameEnv string
+	tokenEnv    string
+}
+
+func newEnvHelper(_ *Client, r Registry) (AuthHelper, error) {
+	if r.TokenEnv == "" {
+		return nil, errors.New(fmt.Errorf("'tokenEnv' %w '%s'", ErrMissingCredential, Env))
+	}
+	return &envHelper{username: r.Username, usernameEnv: r.UsernameEnv, tokenEnv: r.TokenEnv}, nil
+}
+
+func (h *envHelper) Hosts() []string {
+	return nil
+}
+
+func (h *envHelper) AuthConfig(host string) (types.AuthConfig, error) {
+	token, ok := os.LookupEnv(h.tokenEnv)
+	if !ok || token == "" {
+		return types.AuthConfig{}, errors.New(fmt.Errorf("'%s' is not set in the environment", h.tokenEnv))
+	}
+	username := h.username
+	if h.usernameEnv != "" {
+		username = os.Getenv(h.usernameEnv)
+	}
+	return types.AuthConfig{Username: username, Password: token, ServerAddress: host}, nil
+}
+
+// ghcrHelper authenticates the GitHub Container Registry with the GitHub
+// credentials ankor is configured with.
+type ghcrHelper struct{}
+
+func newGHCRHelper(_ *Client, _ Registry) (AuthHelper, error) {
+	return &ghcrHelper{}, nil
+}
+
+func (h *ghcrHelper) Hosts() []string {
+	return []string{ghcrHost}
+}
+
+func (h *ghcrHelper) AuthConfig(host string) (types.AuthConfig, error) {
+	token := viper.GetString("git.github.token")
+	if token == "" {
+		return types.AuthConfig{}, errors.New(fmt.Errorf("'git.github.token' %w '%s'", ErrMissingCredential, GHCR))
+	}
+	return types.AuthConfig{Username: viper.GetString("git.github.user"), Password: token, ServerAddress: host}, nil
+}
@@ -0,0 +1,155 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeHelper struct {
+	hosts []string
+	user  string
+}
+
+func (h *fakeHelper) Hosts() []string {
+	return h.hosts
+}
+
+func (h *fakeHelper) AuthConfig(host string) (types.AuthConfig, error) {
+	return types.AuthConfig{Username: h.user, ServerAddress: host}, nil
+}
+
+// useConfig loads cfg as the ankor configuration, isolated from the docker
+// CLI credentials of the machine running the tests.
+func useConfig(t *testing.T, cfg string) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	viper.Reset()
+	viper.SetConfigType("json")
+	_ = viper.ReadConfig(strings.NewReader(cfg))
+}
+
+func TestAuthHelpers(t *testing.T) {
+	t.Run("registries select a helper per host", func(t *testing.T) {
+		t.Setenv("QUAY_USER", "robot")
+		t.Setenv("QUAY_TOKEN", "quay-token")
+		useConfig(t, `{
+			"git": {"github": {"user": "octocat", "token": "ghp_token"}},
+			"docker": {"registries": [
+				{"host": "ghcr.io", "helper": "ghcr"},
+				{"host": "registry.example.com", "helper": "static", "username": "ci", "token": "static-token"},
+				{"host": "quay.io", "helper": "env", "usernameEnv": "QUAY_USER", "tokenEnv": "QUAY_TOKEN"}
+			]}
+		}`)
+
+		d, _ := NewClient()
+		result, err := d.GetAuthConfig()
+		assert.NoError(t, err)
+		assert.Len(t, result, 3)
+		assert.Equal(t, "octocat", result["ghcr.io"].Username)
+		assert.Equal(t, "ghp_token", result["ghcr.io"].Password)
+		assert.Equal(t, "ci", result["registry.example.com"].Username)
+		assert.Equal(t, "static-token", result["registry.example.com"].Password)
+		assert.Equal(t, "robot", result["quay.io"].Username)
+		assert.Equal(t, "quay-token", result["quay.io"].Password)
+	})
+
+	t.Run("registries override enabled helpers", func(t *testing.T) {
+		useConfig(t, `{"docker": {
+			"authentication": ["custom"],
+			"registries": [{"host": "b.example.com", "helper": "static", "token": "override"}]
+		}}`)
+
+		d, _ := NewClient(WithAuthHelper("custom", func(*Client, Registry) (AuthHelper, error) {
+			return &fakeHelper{hosts: []string{"a.example.com", "b.example.com"}, user: "custom"}, nil
+		}))
+		result, err := d.GetAuthConfig()
+		assert.NoError(t, err)
+		assert.Equal(t, "custom", result["a.example.com"].Username)
+		assert.Equal(t, "override", result["b.example.com"].Password)
+	})
+
+	t.Run("unknown helper", func(t *testing.T) {
+		useConfig(t, `{"docker": {"registries": [{"host": "quay.io", "helper": "nope"}]}}`)
+
+		d, _ := NewClient()
+		_, err := d.GetAuthConfig()
+		assert.ErrorIs(t, err, ErrUnknownAuthHelper)
+	})
+
+	t.Run("missing credentials", func(t *testing.T) {
+		useConfig(t, `{"docker": {"registries": [{"host": "registry.example.com", "helper": "static"}]}}`)
+
+		d, _ := NewClient()
+		_, err := d.GetAuthConfig()
+		assert.ErrorIs(t, err, ErrMissingCredential)
+	})
+
+	t.Run("missing host", func(t *testing.T) {
+		useConfig(t, `{"docker": {"registries": [{"helper": "ghcr"}]}}`)
+
+		d, _ := NewClient()
+		_, err := d.GetAuthConfig()
+		assert.ErrorIs(t, err, ErrMissingOption)
+	})
+
+	t.Run("env helper requires the variable", func(t *testing.T) {
+		useConfig(t, `{"docker": {"registries": [{"host": "quay.io", "helper": "env", "tokenEnv": "ANKOR_TEST_UNSET_TOKEN"}]}}`)
+
+		d, _ := NewClient()
+		_, err := d.GetAuthConfig()
+		assert.ErrorContains(t, err, "ANKOR_TEST_UNSET_TOKEN")
+	})
+
+	t.Run("nil factory", func(t *testing.T) {
+		_, err := NewClient(WithAuthHelper("custom", nil))
+		assert.Error(t, err)
+	})
+}
+
+func TestGcloudHelper(t *testing.T) {
+	authMock := useGcloudConfig(t)
+
+	t.Run("serves configured artifact registry regions", func(t *testing.T) {
+		useConfig(t, `{"docker": {"authentication": ["gcloud"], "gcloud": {"regions": ["europe-west1", "us-central1"]}}}`)
+
+		d, _ := NewClient(WithAuthenticator(authMock))
+		result, err := d.GetAuthConfig()
+		assert.NoError(t, err)
+		assert.Len(t, result, 8)
+		assert.Equal(t, "ya29.encryptedtoken", result["europe-west1-docker.pkg.dev"].Password)
+		assert.Equal(t, "ya29.encryptedtoken", result["us-central1-docker.pkg.dev"].Password)
+	})
+
+	t.Run("serves the artifact registry of references", func(t *testing.T) {
+		useConfig(t, `{"docker": {"authentication": ["gcloud"]}}`)
+
+		d, _ := NewClient(WithAuthenticator(authMock))
+		result, err := d.GetAuthConfig("europe-west4-docker.pkg.dev/ankorstore/tools/ankor:v1", "busybox", "ghcr.io/ankorstore/ankor")
+		assert.NoError(t, err)
+		assert.Len(t, result, 7)
+		assert.Equal(t, gcloudUsername, result["europe-west4-docker.pkg.dev"].Username)
+		assert.Equal(t, "ya29.encryptedtoken", result["europe-west4-docker.pkg.dev"].Password)
+	})
+
+	t.Run("ignores references when gcloud is not enabled", func(t *testing.T) {
+		useConfig(t, `{}`)
+
+		d, _ := NewClient(WithAuthenticator(authMock))
+		result, err := d.GetAuthConfig("europe-west4-docker.pkg.dev/ankorstore/tools/ankor:v1")
+		assert.NoError(t, err)
+		assert.Empty(t, result)
+	})
+
+	t.Run("matches google registries", func(t *testing.T) {
+		h := &gcloudHelper{}
+		for _, host := range []string{"gcr.io", "eu.gcr.io", "europe-west1-docker.pkg.dev"} {
+			assert.True(t, h.Matches(host), host)
+		}
+		for _, host := range []string{"docker.io", "ghcr.io", "notgcr.io", "docker.pkg.dev"} {
+			assert.False(t, h.Matches(host), host)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package docker
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/go-errors/errors"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+)
+
+type BuildOpt func(*BuildConfig) error
+
+type BuildConfig struct {
+	Options types.ImageBuildOptions
+	Secrets []secretsprovider.Source
+	Push    bool
+	declarations
+}
+
+// newBuildConfig applies the supplied options over the defaults of
+// `docker build --pull --force-rm`.
+func newBuildConfig(opts ...BuildOpt) (*BuildConfig, error) {
+	cfg := &BuildConfig{
+		Options: types.ImageBuildOptions{
+			Dockerfile:  DefaultDockerfile,
+			ForceRemove: true,
+			PullParent:  true,
+		},
+	}
+	for _, o := range opts {
+		if err := o(cfg); err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+	}
+	if cfg.Push && len(cfg.Options.Tags) == 0 {
+		return nil, errors.New(fmt.Errorf("'Tags' %w to push", ErrMissingOption))
+	}
+	return cfg, nil
+}
+
+// BuildWithTags tags the built image, e.g. with both `latest` and a SHA.
+func BuildWithTags(tags ...string) BuildOpt {
+	return func(cfg *BuildConfig) error {
+		cfg.Options.Tags = append(cfg.Options.Tags, tags...)
+		return nil
+	}
+}
+
+// BuildWithDockerfile sets the Dockerfile path, relative to the build
+// context unless absolute. Defaults to Dockerfile.
+func BuildWithDockerfile(dockerfile string) BuildOpt {
+	return func(cfg *BuildConfig) error {
+		if err := cfg.declare("Dockerfile"); err != nil {
+			return err
+		}
+		cfg.Options.Dockerfile = dockerfile
+		return nil
+	}
+}
+
+// BuildWithBuildArgs sets build-time variables, like `--build-arg`.
+func BuildWithBuildArgs(args map[string]string) BuildOpt {
+	return func(cfg *BuildConfig) error {
+		if cfg.Options.BuildArgs == nil {
+			cfg.Options.BuildArgs = map[string]*string{}
+		}
+		for k, v := range args {
+			v := v
+			cfg.Options.BuildArgs[k] = &v
+		}
+		return nil
+	}
+}
+
+// BuildWithTarget sets the stage of a multi-stage build to stop at.
+func BuildWithTarget(target string) BuildOpt {
+	return func(cfg *BuildConfig) error {
+		if err := cfg.declare("Target"); err != nil {
+			return err
+		}
+		cfg.Options.Target = target
+		return nil
+	}
+}
+
+// BuildWithLabels adds metadata labels to the built image.
+func BuildWithLabels(labels map[string]string) BuildOpt {
+	return func(cfg *BuildConfig) error {
+		if cfg.Options.Labels == nil {
+			cfg.Options.Labels = map[string]string{}
+		}
+		for k, v := range labels {
+			cfg.Options.Labels[k] = v
+		}
+		return nil
+	}
+}
+
+// BuildWithCacheFrom sets images to consider as cache sources.
+func BuildWithCacheFrom(images ...string) BuildOpt {
+	return func(cfg *BuildConfig) error {
+		cfg.Options.CacheFrom = append(cfg.Options.CacheFrom, images...)
+		return nil
+	}
+}
+
+// BuildWithPullParent sets whether newer versions of base images are always
+// pulled. Defaults to true.
+func BuildWithPullParent(pull bool) BuildOpt {
+	return func(cfg *BuildConfig) error {
+		if err := cfg.declare("PullParent"); err != nil {
+			return err
+		}
+		cfg.Options.PullParent = pull
+		return nil
+	}
+}
+
+// BuildWithNoCache disables the build cache.
+func BuildWithNoCache() BuildOpt {
+	return func(cfg *BuildConfig) error {
+		if err := cfg.declare("NoCache"); err != nil {
+			return err
+		}
+		cfg.Options.NoCache = true
+		return nil
+	}
+}
+
+// BuildWithPlatform sets the target platform as os/arch[/variant].
+func BuildWithPlatform(platform string) BuildOpt {
+	return func(cfg *BuildConfig) error {
+		if err := cfg.declare("Platform"); err != nil {
+			return err
+		}
+		cfg.Options.Platform = platform
+		return nil
+	}
+}
+
+// BuildWithSecret exposes the file at path to `RUN --mount=type=secret,id=<id>`
+// instructions. Secrets require BuildKit.
+func BuildWithSecret(id, path string) BuildOpt {
+	return func(cfg *BuildConfig) error {
+		for _, s := range cfg.Secrets {
+			if s.ID == id {
+				return errors.New(fmt.Errorf("'Secret %s' %w", id, ErrCannotRedeclare))
+			}
+		}
+		cfg.Secrets = append(cfg.Secrets, secretsprovider.Source{ID: id, FilePath: path})
+		return nil
+	}
+}
+
+// BuildWithPush pushes every tag of the image once the build succeeds.
+func BuildWithPush() BuildOpt {
+	return func(cfg *BuildConfig) error {
+		if err := cfg.declare("Push"); err != nil {
+			return err
+		}
+		cfg.Push = true
+		return nil
+	}
+}
@@ -0,0 +1,151 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ankorstore/ankorstore-cli-modules/pkg/docker/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// buildContextReader reads the build context streamed to ImageBuild before
+// the mock records the call, as the mock formats its arguments while the
+// archive would otherwise still be written to the pipe.
+type buildContextReader struct {
+	*mocks.ImageAPIClient
+}
+
+func (b buildContextReader) ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	content, err := io.ReadAll(buildContext)
+	if err != nil {
+		return types.ImageBuildResponse{}, err
+	}
+	return b.ImageAPIClient.ImageBuild(ctx, bytes.NewReader(content), options)
+}
+
+func TestBuildConfig(t *testing.T) {
+	t.Run("with defaults", func(t *testing.T) {
+		cfg, err := newBuildConfig()
+		assert.NoError(t, err)
+		assert.Equal(t, DefaultDockerfile, cfg.Options.Dockerfile)
+		assert.True(t, cfg.Options.PullParent)
+		assert.True(t, cfg.Options.ForceRemove)
+	})
+
+	t.Run("with all options", func(t *testing.T) {
+		cfg, err := newBuildConfig(
+			BuildWithTags("ankor:latest", "ankor:abc123"),
+			BuildWithTags("ankor:v1"),
+			BuildWithDockerfile("build/Dockerfile"),
+			BuildWithBuildArgs(map[string]string{"GO_VERSION": "1.18"}),
+			BuildWithTarget("builder"),
+			BuildWithLabels(map[string]string{"team": "platform"}),
+			BuildWithCacheFrom("ankor:latest"),
+			BuildWithPullParent(false),
+			BuildWithNoCache(),
+			BuildWithPlatform("linux/amd64"),
+			BuildWithSecret("npmrc", "/home/ankor/.npmrc"),
+		)
+		assert.NoError(t, err)
+
+		o := cfg.Options
+		assert.Equal(t, []string{"ankor:latest", "ankor:abc123", "ankor:v1"}, o.Tags)
+		assert.Equal(t, "build/Dockerfile", o.Dockerfile)
+		assert.Equal(t, "1.18", *o.BuildArgs["GO_VERSION"])
+		assert.Equal(t, "builder", o.Target)
+		assert.Equal(t, "platform", o.Labels["team"])
+		assert.Equal(t, []string{"ankor:latest"}, o.CacheFrom)
+		assert.False(t, o.PullParent)
+		assert.True(t, o.NoCache)
+		assert.Equal(t, "linux/amd64", o.Platform)
+		assert.Equal(t, "npmrc", cfg.Secrets[0].ID)
+		assert.Equal(t, "/home/ankor/.npmrc", cfg.Secrets[0].FilePath)
+	})
+
+	t.Run("with redeclared options", func(t *testing.T) {
+		_, err := newBuildConfig(BuildWithTarget("builder"), BuildWithTarget("runtime"))
+		assert.ErrorIs(t, err, ErrCannotRedeclare)
+		assert.Equal(t, "'Target' cannot be declared more than once", err.Error())
+
+		_, err = newBuildConfig(BuildWithSecret("npmrc", "a"), BuildWithSecret("npmrc", "b"))
+		assert.ErrorIs(t, err, ErrCannotRedeclare)
+	})
+}
+
+func TestBuildImage(t *testing.T) {
+	viper.Reset()
+	windows := &mocks.SystemAPIClient{}
+	windows.On("Ping", mock.Anything).Return(types.Ping{APIVersion: "1.41", OSType: "windows"}, nil)
+
+	t.Run("passes options to the daemon", func(t *testing.T) {
+		b := &mocks.ImageAPIClient{}
+		b.On("ImageBuild", mock.Anything, mock.Anything, mock.MatchedBy(func(opts types.ImageBuildOptions) bool {
+			return assert.ObjectsAreEqual([]string{"ankor:latest", "ankor:abc123"}, opts.Tags) &&
+				opts.Target == "builder" &&
+				*opts.BuildArgs["GO_VERSION"] == "1.18" &&
+				opts.Dockerfile == "Dockerfile"
+		})).
+			Once().
+			Return(types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(`{"stream": "done"}
+{"aux": {"ID": "sha256:2f9d53a9e3e1"}}`))}, nil)
+
+		d, _ := NewClient(WithImageClient(buildContextReader{b}), WithContainerClient(&mocks.ContainerAPIClient{}), WithSystemClient(windows), WithHijackDialer(&mocks.HijackDialer{}))
+		imageID, err := d.BuildImage(context.Background(), "./testdata",
+			BuildWithTags("ankor:latest", "ankor:abc123"),
+			BuildWithTarget("builder"),
+			BuildWithBuildArgs(map[string]string{"GO_VERSION": "1.18"}))
+		assert.NoError(t, err)
+		assert.Equal(t, "sha256:2f9d53a9e3e1", imageID)
+		b.AssertExpectations(t)
+	})
+
+	t.Run("returns build errors", func(t *testing.T) {
+		b := &mocks.ImageAPIClient{}
+		b.On("ImageBuild", mock.Anything, mock.Anything, mock.Anything).
+			Once().
+			Return(types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(`{"errorDetail": {"message": "failed"}, "error": "failed"}`))}, nil)
+
+		d, _ := NewClient(WithImageClient(buildContextReader{b}), WithContainerClient(&mocks.ContainerAPIClient{}), WithSystemClient(windows), WithHijackDialer(&mocks.HijackDialer{}))
+		_, err := d.BuildImage(context.Background(), "./testdata", BuildWithTags("ankor:latest"), BuildWithPush())
+		assert.ErrorContains(t, err, "failed")
+		b.AssertNotCalled(t, "ImagePush", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("authenticates the registries of base images", func(t *testing.T) {
+		authMock := useGcloudConfig(t)
+		defer viper.Reset()
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{"Dockerfile": "FROM europe-west1-docker.pkg.dev/ankorstore/base/go:1.18\n"})
+
+		b := &mocks.ImageAPIClient{}
+		b.On("ImageBuild", mock.Anything, mock.Anything, mock.MatchedBy(func(opts types.ImageBuildOptions) bool {
+			return opts.AuthConfigs["europe-west1-docker.pkg.dev"].Password == "ya29.encryptedtoken"
+		})).
+			Once().
+			Return(types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(`{"stream": "done"}`))}, nil)
+
+		d, _ := NewClient(WithImageClient(buildContextReader{b}), WithContainerClient(&mocks.ContainerAPIClient{}), WithSystemClient(windows),
+			WithHijackDialer(&mocks.HijackDialer{}), WithAuthenticator(authMock))
+		_, err := d.BuildImage(context.Background(), dir)
+		assert.NoError(t, err)
+		b.AssertExpectations(t)
+	})
+
+	t.Run("requires buildkit for secrets", func(t *testing.T) {
+		d, _ := NewClient(WithImageClient(&mocks.ImageAPIClient{}), WithContainerClient(&mocks.ContainerAPIClient{}), WithSystemClient(windows), WithHijackDialer(&mocks.HijackDialer{}))
+		_, err := d.BuildImage(context.Background(), "./testdata", BuildWithSecret("npmrc", "./testdata/test.env"))
+		assert.ErrorContains(t, err, "require BuildKit")
+	})
+
+	t.Run("with invalid options", func(t *testing.T) {
+		d, _ := NewClient(WithImageClient(&mocks.ImageAPIClient{}), WithContainerClient(&mocks.ContainerAPIClient{}), WithSystemClient(windows), WithHijackDialer(&mocks.HijackDialer{}))
+		_, err := d.BuildImage(context.Background(), "./testdata", BuildWithNoCache(), BuildWithNoCache())
+		assert.ErrorIs(t, err, ErrCannotRedeclare)
+	})
+}
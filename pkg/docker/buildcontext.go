@@ -0,0 +1,228 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/fileutils"
+	"github.com/docker/docker/pkg/idtools"
+	"github.com/go-errors/errors"
+	"github.com/moby/buildkit/frontend/dockerfile/dockerignore"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+const (
+	DefaultDockerfile = "Dockerfile"
+	dockerignoreFile  = ".dockerignore"
+)
+
+// createBuildContext streams contextDir as a tar archive honoring its
+// .dockerignore. It returns the archive together with the path of the
+// Dockerfile inside it, which is relative to contextDir unless absolute.
+// A Dockerfile living outside the context is added to the archive under a
+// generated name.
+func createBuildContext(contextDir, dockerfile string) (io.ReadCloser, string, error) {
+	contextDir, err := filepath.Abs(contextDir)
+	if err != nil {
+		return nil, "", errors.Wrap(err, 0)
+	}
+	stat, err := os.Stat(contextDir)
+	if err != nil {
+		return nil, "", errors.Wrap(err, 0)
+	}
+	if !stat.IsDir() {
+		return nil, "", errors.New(fmt.Errorf("build context %s is not a directory", contextDir))
+	}
+
+	if dockerfile == "" {
+		dockerfile = DefaultDockerfile
+	}
+	if !filepath.IsAbs(dockerfile) {
+		dockerfile = filepath.Join(contextDir, dockerfile)
+	}
+	if _, err := os.Stat(dockerfile); err != nil {
+		return nil, "", errors.WrapPrefix(err, "cannot locate Dockerfile", 0)
+	}
+
+	excludes, err := readDockerignore(contextDir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	relDockerfile, err := filepath.Rel(contextDir, dockerfile)
+	outside := err != nil || strings.HasPrefix(relDockerfile, ".."+string(filepath.Separator))
+	if !outside {
+		// the daemon needs these even when the context ignores them
+		excludes = append(excludes, "!"+filepath.ToSlash(relDockerfile), "!"+dockerignoreFile)
+	}
+
+	if err := validateBuildContext(contextDir, excludes); err != nil {
+		return nil, "", err
+	}
+
+	buildCtx, err := archive.TarWithOptions(contextDir, &archive.TarOptions{
+		ExcludePatterns: excludes,
+		ChownOpts:       &idtools.Identity{UID: 0, GID: 0},
+	})
+	if err != nil {
+		return nil, "", errors.Wrap(err, 0)
+	}
+
+	if outside {
+		return addDockerfileToBuildContext(buildCtx, dockerfile)
+	}
+	return buildCtx, filepath.ToSlash(relDockerfile), nil
+}
+
+func readDockerignore(contextDir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(contextDir, dockerignoreFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+	defer func() { _ = f.Close() }()
+
+	excludes, err := dockerignore.ReadAll(f)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "reading "+dockerignoreFile, 0)
+	}
+	return excludes, nil
+}
+
+// validateBuildContext checks every file that will be sent to the daemon can
+// be read, as errors are otherwise swallowed while the archive is streamed.
+func validateBuildContext(contextDir string, excludes []string) error {
+	pm, err := fileutils.NewPatternMatcher(excludes)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	return filepath.Walk(contextDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsPermission(err) {
+				return errors.New(fmt.Errorf("can't stat '%s'", path))
+			}
+			return errors.Wrap(err, 0)
+		}
+
+		rel, err := filepath.Rel(contextDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		skip, err := pm.Matches(rel)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+		if skip {
+			if !info.IsDir() {
+				return nil
+			}
+			// ignored directories are only walked when an exception may
+			// include some of their files, as archive.TarWithOptions does
+			dirSlash := rel + string(filepath.Separator)
+			for _, pat := range pm.Patterns() {
+				if pat.Exclusion() && strings.HasPrefix(pat.String()+string(filepath.Separator), dirSlash) {
+					return nil
+				}
+			}
+			return filepath.SkipDir
+		}
+
+		if info.Mode().IsRegular() {
+			f, err := os.Open(path)
+			if err != nil {
+				return errors.New(fmt.Errorf("can't open '%s': %w", path, err))
+			}
+			_ = f.Close()
+		}
+		return nil
+	})
+}
+
+// addDockerfileToBuildContext injects a Dockerfile from outside the context
+// under a random name, which is also ignored so it cannot be copied into the
+// image.
+func addDockerfileToBuildContext(buildCtx io.ReadCloser, dockerfile string) (io.ReadCloser, string, error) {
+	content, err := os.ReadFile(dockerfile)
+	if err != nil {
+		_ = buildCtx.Close()
+		return nil, "", errors.Wrap(err, 0)
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		_ = buildCtx.Close()
+		return nil, "", errors.Wrap(err, 0)
+	}
+	name := ".dockerfile." + hex.EncodeToString(b)
+	now := time.Now()
+
+	buildCtx = archive.ReplaceFileTarWrapper(buildCtx, map[string]archive.TarModifierFunc{
+		name: func(_ string, _ *tar.Header, _ io.Reader) (*tar.Header, []byte, error) {
+			return &tar.Header{
+				Name:       name,
+				Mode:       0600,
+				ModTime:    now,
+				Typeflag:   tar.TypeReg,
+				AccessTime: now,
+				ChangeTime: now,
+			}, content, nil
+		},
+		dockerignoreFile: func(_ string, h *tar.Header, r io.Reader) (*tar.Header, []byte, error) {
+			if h == nil {
+				h = &tar.Header{Name: dockerignoreFile, Mode: 0600, Typeflag: tar.TypeReg, ModTime: now}
+			}
+			b := &bytes.Buffer{}
+			if r != nil {
+				if _, err := b.ReadFrom(r); err != nil {
+					return nil, nil, err
+				}
+			}
+			b.WriteString("\n" + name + "\n")
+			return h, b.Bytes(), nil
+		},
+	})
+	return buildCtx, name, nil
+}
+
+// dockerfileImages lists the images a Dockerfile builds from, leaving out
+// earlier stages, scratch and references depending on build arguments.
+func dockerfileImages(dockerfile string) ([]string, error) {
+	f, err := os.Open(dockerfile)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+	defer func() { _ = f.Close() }()
+
+	result, err := parser.Parse(f)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "error parsing Dockerfile", 0)
+	}
+
+	var images []string
+	stages := map[string]bool{"scratch": true}
+	for _, node := range result.AST.Children {
+		if !strings.EqualFold(node.Value, "from") || node.Next == nil {
+			continue
+		}
+		image := node.Next.Value
+		if !stages[strings.ToLower(image)] && !strings.Contains(image, "$") {
+			images = append(images, image)
+		}
+		if as := node.Next.Next; as != nil && strings.EqualFold(as.Value, "as") && as.Next != nil {
+			stages[strings.ToLower(as.Next.Value)] = true
+		}
+	}
+	return images, nil
+}
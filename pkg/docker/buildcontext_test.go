@@ -0,0 +1,164 @@
+package docker
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func readTar(t *testing.T, r io.Reader) map[string]string {
+	entries := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		assert.NoError(t, err)
+		b, err := io.ReadAll(tr)
+		assert.NoError(t, err)
+		entries[strings.TrimSuffix(h.Name, "/")] = string(b)
+	}
+}
+
+func TestCreateBuildContext(t *testing.T) {
+	t.Run("honors .dockerignore", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{
+			"Dockerfile":                  "FROM busybox",
+			".dockerignore":               "node_modules\n*.log\nDockerfile\n.dockerignore\n",
+			"main.go":                     "package main",
+			"debug.log":                   "noise",
+			"node_modules/left-pad/index": "module.exports = {}",
+		})
+
+		buildCtx, dockerfile, err := createBuildContext(dir, "")
+		assert.NoError(t, err)
+		defer func() { _ = buildCtx.Close() }()
+
+		entries := readTar(t, buildCtx)
+		assert.Equal(t, "Dockerfile", dockerfile)
+		assert.Contains(t, entries, "main.go")
+		assert.Contains(t, entries, "Dockerfile", "the Dockerfile is always sent")
+		assert.Contains(t, entries, ".dockerignore", "the .dockerignore is always sent")
+		assert.NotContains(t, entries, "debug.log")
+		assert.NotContains(t, entries, "node_modules")
+		assert.NotContains(t, entries, "node_modules/left-pad/index")
+	})
+
+	t.Run("with a Dockerfile in a sub directory", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{
+			"build/Dockerfile.ci": "FROM busybox",
+			"main.go":             "package main",
+		})
+
+		buildCtx, dockerfile, err := createBuildContext(dir, "build/Dockerfile.ci")
+		assert.NoError(t, err)
+		defer func() { _ = buildCtx.Close() }()
+
+		assert.Equal(t, "build/Dockerfile.ci", dockerfile)
+		assert.Contains(t, readTar(t, buildCtx), "build/Dockerfile.ci")
+	})
+
+	t.Run("with a Dockerfile outside the context", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{
+			"Dockerfile":  "FROM busybox",
+			"app/main.go": "package main",
+		})
+
+		buildCtx, dockerfile, err := createBuildContext(filepath.Join(dir, "app"), filepath.Join(dir, "Dockerfile"))
+		assert.NoError(t, err)
+		defer func() { _ = buildCtx.Close() }()
+
+		entries := readTar(t, buildCtx)
+		assert.True(t, strings.HasPrefix(dockerfile, ".dockerfile."))
+		assert.Equal(t, "FROM busybox", entries[dockerfile])
+		assert.Contains(t, entries[".dockerignore"], dockerfile)
+		assert.Contains(t, entries, "main.go")
+	})
+
+	t.Run("with a missing context", func(t *testing.T) {
+		_, _, err := createBuildContext(filepath.Join(t.TempDir(), "missing"), "")
+		assert.Error(t, err)
+	})
+
+	t.Run("with a missing Dockerfile", func(t *testing.T) {
+		_, _, err := createBuildContext(t.TempDir(), "")
+		assert.ErrorContains(t, err, "cannot locate Dockerfile")
+	})
+
+	t.Run("with an unreadable file", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("file permissions are not enforced for root")
+		}
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{"Dockerfile": "FROM busybox", "secret": "s3cr3t"})
+		assert.NoError(t, os.Chmod(filepath.Join(dir, "secret"), 0000))
+
+		_, _, err := createBuildContext(dir, "")
+		assert.ErrorContains(t, err, "can't open")
+	})
+
+	t.Run("does not walk ignored directories", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("file permissions are not enforced for root")
+		}
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{
+			"Dockerfile":                  "FROM busybox",
+			".dockerignore":               "node_modules\nDockerfile\n",
+			"node_modules/left-pad/index": "module.exports = {}",
+			"vendor/keep/main.go":         "package keep",
+		})
+		// walking into node_modules would fail to list its packages
+		pkg := filepath.Join(dir, "node_modules", "left-pad")
+		assert.NoError(t, os.Chmod(pkg, 0000))
+		t.Cleanup(func() { _ = os.Chmod(pkg, 0755) })
+
+		buildCtx, _, err := createBuildContext(dir, "")
+		assert.NoError(t, err)
+		defer func() { _ = buildCtx.Close() }()
+
+		entries := readTar(t, buildCtx)
+		assert.Contains(t, entries, "Dockerfile")
+		assert.Contains(t, entries, "vendor/keep/main.go")
+		assert.NotContains(t, entries, "node_modules")
+	})
+}
+
+func TestDockerfileImages(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"Dockerfile": `ARG BASE=alpine
+FROM --platform=linux/amd64 europe-west1-docker.pkg.dev/ankorstore/base/go:1.18 AS builder
+RUN go build
+FROM builder AS test
+FROM ${BASE}
+from scratch
+FROM gcr.io/distroless/static
+COPY --from=builder /app /app
+`,
+	})
+
+	images, err := dockerfileImages(filepath.Join(dir, "Dockerfile"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"europe-west1-docker.pkg.dev/ankorstore/base/go:1.18", "gcr.io/distroless/static"}, images)
+
+	_, err = dockerfileImages(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
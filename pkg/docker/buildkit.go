@@ -0,0 +1,276 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/go-errors/errors"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/moby/buildkit/identity"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/auth"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+)
+
+const (
+	buildKitTraceID = "moby.buildkit.trace"
+	buildKitImageID = "moby.image.id"
+	// minBuildKitAPIVersion is the first API version exposing BuildKit
+	// sessions on the /session endpoint.
+	minBuildKitAPIVersion = "1.39"
+)
+
+// supportsBuildKit reports whether BuildKit is enabled and available on the
+// daemon, so callers can fall back to the classic builder.
+func (c *Client) supportsBuildKit(ctx context.Context) bool {
+	if !c.buildKit {
+		return false
+	}
+	ping, err := c.system.Ping(ctx)
+	if err != nil {
+		log.Debug().Err(err).Msg("Could not ping docker daemon, using the classic builder")
+		return false
+	}
+	if ping.OSType == "windows" || versions.LessThan(ping.APIVersion, minBuildKitAPIVersion) {
+		log.Debug().Msgf("Docker daemon (%s, API %s) does not support BuildKit, using the classic builder", ping.OSType, ping.APIVersion)
+		return false
+	}
+	return true
+}
+
+// buildWithBuildKit runs the build through a BuildKit session that serves
+// registry credentials and secrets to the daemon for the duration of the
+// build. It returns the ID of the built image. Cancelling ctx cancels the
+// build in the daemon.
+func (c *Client) buildWithBuildKit(ctx context.Context, buildContext io.Reader, opts types.ImageBuildOptions, secrets []secretsprovider.Source, p *progress) (string, error) {
+	s, err := session.NewSession(ctx, "ankor", "")
+	if err != nil {
+		return "", errors.Wrap(err, 0)
+	}
+	s.Allow(&authProvider{auths: opts.AuthConfigs})
+	if len(secrets) > 0 {
+		store, err := secretsprovider.NewStore(secrets)
+		if err != nil {
+			return "", errors.Wrap(err, 0)
+		}
+		s.Allow(secretsprovider.NewSecretProvider(store))
+	}
+
+	// the session is cancelled once the build is over, as Session.Close does
+	// not stop a session still dialling the daemon
+	sessionCtx, cancelSession := context.WithCancel(ctx)
+	defer cancelSession()
+	sessionDone := make(chan error, 1)
+	go func() {
+		sessionDone <- s.Run(sessionCtx, func(ctx context.Context, proto string, meta map[string][]string) (net.Conn, error) {
+			return c.dialer.DialHijack(ctx, "/session", proto, meta)
+		})
+	}()
+
+	opts.Version = types.BuilderBuildKit
+	opts.SessionID = s.ID()
+	opts.BuildID = identity.NewID()
+
+	imageID, buildErr := func() (string, error) {
+		response, err := c.images.ImageBuild(ctx, buildContext, opts)
+		if err != nil {
+			return "", errors.Wrap(err, 0)
+		}
+		defer func() { _ = response.Body.Close() }()
+		return printBuildKitOutput(response.Body, p)
+	}()
+
+	if ctx.Err() != nil {
+		c.cancelBuild(opts.BuildID)
+	}
+	cancelSession()
+	if err := <-sessionDone; err != nil && buildErr == nil {
+		log.Debug().Err(err).Msg("BuildKit session ended with an error")
+	}
+	_ = s.Close()
+	return imageID, buildErr
+}
+
+// cancelBuild asks the daemon to cancel a BuildKit build whose context is
+// done, as it may otherwise run to completion.
+func (c *Client) cancelBuild(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	log.Info().Msgf("Cancelling build %s", id)
+	if err := c.images.BuildCancel(ctx, id); err != nil {
+		log.Debug().Err(err).Msgf("Could not cancel build %s", id)
+	}
+}
+
+// printBuildKitOutput decodes the BuildKit progress stream, logging vertexes
+// as they start and complete together with their output and rendering them
+// on progress, and returns the ID of the built image.
+func printBuildKitOutput(reader io.Reader, progress *progress) (string, error) {
+	var imageID string
+	p := newBuildKitPrinter(progress)
+	decoder := json.NewDecoder(reader)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return imageID, nil
+			}
+			return imageID, errors.Wrap(err, 0)
+		}
+		if msg.Error != nil {
+			return imageID, errors.New(msg.Error)
+		}
+		if msg.ID == buildKitImageID && msg.Aux != nil {
+			var result types.BuildResult
+			if err := json.Unmarshal(*msg.Aux, &result); err != nil {
+				return imageID, errors.Wrap(err, 0)
+			}
+			imageID = result.ID
+			continue
+		}
+		if msg.ID != buildKitTraceID || msg.Aux == nil {
+			progress.update(msg)
+			for _, l := range strings.Split(msg.Stream, "\n") {
+				p.log(l)
+			}
+			continue
+		}
+
+		var data []byte
+		if err := json.Unmarshal(*msg.Aux, &data); err != nil {
+			return imageID, errors.Wrap(err, 0)
+		}
+		var status controlapi.StatusResponse
+		if err := status.Unmarshal(data); err != nil {
+			return imageID, errors.Wrap(err, 0)
+		}
+		p.print(&status)
+	}
+}
+
+// buildKitPrinter renders BuildKit status updates in the same numbered
+// format as `docker build --progress=plain`.
+type buildKitPrinter struct {
+	index     map[string]int
+	names     map[string]string
+	started   map[string]bool
+	completed map[string]bool
+	progress  *progress
+}
+
+func newBuildKitPrinter(progress *progress) *buildKitPrinter {
+	return &buildKitPrinter{
+		index:     map[string]int{},
+		names:     map[string]string{},
+		started:   map[string]bool{},
+		completed: map[string]bool{},
+		progress:  progress,
+	}
+}
+
+func (p *buildKitPrinter) number(vertex string) int {
+	if _, ok := p.index[vertex]; !ok {
+		p.index[vertex] = len(p.index) + 1
+	}
+	return p.index[vertex]
+}
+
+func (p *buildKitPrinter) print(status *controlapi.StatusResponse) {
+	for _, v := range status.Vertexes {
+		d := v.Digest.String()
+		n := p.number(d)
+		p.names[d] = v.Name
+		if v.Started != nil && !p.started[d] {
+			p.started[d] = true
+			p.log(fmt.Sprintf("#%d %s", n, v.Name))
+			p.update(d, "running", 0, 0)
+		}
+		if v.Completed == nil || p.completed[d] {
+			continue
+		}
+		p.completed[d] = true
+		var result string
+		switch {
+		case v.Error != "":
+			result = "ERROR: " + v.Error
+		case v.Cached:
+			result = "CACHED"
+		case v.Started != nil:
+			result = fmt.Sprintf("DONE %.1fs", v.Completed.Sub(*v.Started).Seconds())
+		default:
+			result = "DONE"
+		}
+		p.log(fmt.Sprintf("#%d %s", n, result))
+		p.update(d, result, 0, 0)
+	}
+	for _, s := range status.Statuses {
+		d := s.Vertex.String()
+		n := p.number(d)
+		if !p.completed[d] {
+			p.update(d, s.ID, s.Current, s.Total)
+		}
+		if s.Completed == nil {
+			continue
+		}
+		if s.Total > 0 {
+			p.log(fmt.Sprintf("#%d %s %d/%d done", n, s.ID, s.Current, s.Total))
+		} else {
+			p.log(fmt.Sprintf("#%d %s done", n, s.ID))
+		}
+	}
+	for _, l := range status.Logs {
+		n := p.number(l.Vertex.String())
+		for _, line := range strings.Split(string(l.Msg), "\n") {
+			if len(line) > 0 {
+				p.log(fmt.Sprintf("#%d %s", n, line))
+			}
+		}
+	}
+}
+
+// update renders the state of a vertex, identified by its number and name.
+func (p *buildKitPrinter) update(vertex, status string, current, total int64) {
+	id := fmt.Sprintf("#%d %s", p.number(vertex), p.names[vertex])
+	p.progress.update(jsonmessage.JSONMessage{
+		ID:       strings.TrimSpace(id),
+		Status:   status,
+		Progress: &jsonmessage.JSONProgress{Current: current, Total: total},
+	})
+}
+
+func (p *buildKitPrinter) log(line string) {
+	if len(line) > 0 {
+		log.Debug().Msgf("\t| %s", line)
+	}
+}
+
+// authProvider serves registry credentials to BuildKit over the session.
+type authProvider struct {
+	auth.UnimplementedAuthServer
+	auths map[string]types.AuthConfig
+}
+
+func (a *authProvider) Register(server *grpc.Server) {
+	auth.RegisterAuthServer(server, a)
+}
+
+func (a *authProvider) Credentials(_ context.Context, req *auth.CredentialsRequest) (*auth.CredentialsResponse, error) {
+	ac, ok := authConfigFor(a.auths, req.Host)
+	if !ok {
+		return &auth.CredentialsResponse{}, nil
+	}
+	if ac.IdentityToken != "" {
+		return &auth.CredentialsResponse{Secret: ac.IdentityToken}, nil
+	}
+	return &auth.CredentialsResponse{Username: ac.Username, Secret: ac.Password}, nil
+}
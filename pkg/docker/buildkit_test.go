@@ -0,0 +1,232 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ankorstore/ankorstore-cli-modules/pkg/docker/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/go-errors/errors"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/moby/buildkit/session/auth"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/phpboyscout/zltest"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func traceMessage(t *testing.T, status *controlapi.StatusResponse) string {
+	data, err := status.Marshal()
+	assert.NoError(t, err)
+	aux, err := json.Marshal(data)
+	assert.NoError(t, err)
+	return fmt.Sprintf(`{"id": "moby.buildkit.trace", "aux": %s}`, aux)
+}
+
+func TestSupportsBuildKit(t *testing.T) {
+	cases := []struct {
+		label    string
+		enabled  bool
+		ping     types.Ping
+		err      error
+		expected bool
+	}{
+		{label: "with a linux daemon", enabled: true, ping: types.Ping{APIVersion: "1.41", OSType: "linux"}, expected: true},
+		{label: "with buildkit disabled", enabled: false, ping: types.Ping{APIVersion: "1.41", OSType: "linux"}},
+		{label: "with a windows daemon", enabled: true, ping: types.Ping{APIVersion: "1.41", OSType: "windows"}},
+		{label: "with an old daemon", enabled: true, ping: types.Ping{APIVersion: "1.38", OSType: "linux"}},
+		{label: "with an unreachable daemon", enabled: true, err: errors.New("connection refused")},
+	}
+
+	for _, c := range cases {
+		t.Run(c.label, func(t *testing.T) {
+			s := &mocks.SystemAPIClient{}
+			s.On("Ping", mock.Anything).Return(c.ping, c.err)
+			d, _ := NewClient(WithSystemClient(s), WithBuildKit(c.enabled))
+			assert.Equal(t, c.expected, d.supportsBuildKit(context.Background()))
+		})
+	}
+}
+
+func TestPrintBuildKitOutput(t *testing.T) {
+	helper := zltest.New(t)
+	log.Logger = zerolog.New(helper)
+
+	started := time.Date(2022, 7, 1, 10, 0, 0, 0, time.UTC)
+	completed := started.Add(1500 * time.Millisecond)
+	build := digest.FromString("build")
+	base := digest.FromString("base")
+
+	t.Run("decodes vertexes, statuses and logs", func(t *testing.T) {
+		helper.Reset()
+		stream := strings.Join([]string{
+			traceMessage(t, &controlapi.StatusResponse{
+				Vertexes: []*controlapi.Vertex{
+					{Digest: base, Name: "[1/2] FROM docker.io/library/golang", Started: &started, Completed: &started, Cached: true},
+					{Digest: build, Name: "[2/2] RUN go build ./...", Started: &started},
+				},
+			}),
+			traceMessage(t, &controlapi.StatusResponse{
+				Statuses: []*controlapi.VertexStatus{{ID: "sha256:layer", Vertex: base, Current: 10, Total: 10, Completed: &completed}},
+				Logs:     []*controlapi.VertexLog{{Vertex: build, Msg: []byte("compiling\nlinking\n")}},
+			}),
+			traceMessage(t, &controlapi.StatusResponse{
+				Vertexes: []*controlapi.Vertex{{Digest: build, Name: "[2/2] RUN go build ./...", Started: &started, Completed: &completed}},
+			}),
+			`{"id": "moby.image.id", "aux": {"ID": "sha256:2f9d53a9e3e1"}}`,
+			`{"stream": "Successfully tagged ankor:test\n"}`,
+		}, "\n")
+
+		imageID, err := printBuildKitOutput(strings.NewReader(stream), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "sha256:2f9d53a9e3e1", imageID)
+
+		helper.Entries().ExpMsg("\t| #1 [1/2] FROM docker.io/library/golang")
+		helper.Entries().ExpMsg("\t| #1 CACHED")
+		helper.Entries().ExpMsg("\t| #2 [2/2] RUN go build ./...")
+		helper.Entries().ExpMsg("\t| #1 sha256:layer 10/10 done")
+		helper.Entries().ExpMsg("\t| #2 compiling")
+		helper.Entries().ExpMsg("\t| #2 linking")
+		helper.Entries().ExpMsg("\t| #2 DONE 1.5s")
+		helper.Entries().ExpMsg("\t| Successfully tagged ankor:test")
+	})
+
+	t.Run("reports vertex errors and returns stream errors", func(t *testing.T) {
+		helper.Reset()
+		stream := strings.Join([]string{
+			traceMessage(t, &controlapi.StatusResponse{
+				Vertexes: []*controlapi.Vertex{{Digest: build, Name: "RUN false", Started: &started, Completed: &completed, Error: "exit code: 1"}},
+			}),
+			`{"errorDetail": {"message": "failed to solve"}, "error": "failed to solve"}`,
+		}, "\n")
+
+		_, err := printBuildKitOutput(strings.NewReader(stream), nil)
+		assert.ErrorContains(t, err, "failed to solve")
+		helper.Entries().ExpMsg("\t| #1 ERROR: exit code: 1")
+	})
+
+	t.Run("with malformed json", func(t *testing.T) {
+		_, err := printBuildKitOutput(strings.NewReader(`{"id": "moby.buildkit.trace", `), nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestBuildImageWithBuildKit(t *testing.T) {
+	viper.Reset()
+
+	t.Run("uses a session when the daemon supports buildkit", func(t *testing.T) {
+		s := &mocks.SystemAPIClient{}
+		s.On("Ping", mock.Anything).Return(types.Ping{APIVersion: "1.41", OSType: "linux"}, nil)
+		dialer := &mocks.HijackDialer{}
+		dialer.On("DialHijack", mock.Anything, "/session", "h2c", mock.Anything).Return(nil, errors.New("no session"))
+		b := &mocks.ImageAPIClient{}
+		b.On("ImageBuild", mock.Anything, mock.Anything, mock.MatchedBy(func(opts types.ImageBuildOptions) bool {
+			return opts.Version == types.BuilderBuildKit && opts.SessionID != ""
+		})).
+			Once().
+			Return(types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(`{"stream": "done"}`))}, nil)
+
+		d, _ := NewClient(WithImageClient(buildContextReader{b}), WithContainerClient(&mocks.ContainerAPIClient{}), WithSystemClient(s), WithHijackDialer(dialer))
+		_, err := d.BuildImage(context.Background(), "./testdata", BuildWithTags("ankor:test"))
+		assert.NoError(t, err)
+		b.AssertExpectations(t)
+	})
+
+	t.Run("cancels the build when the context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s := &mocks.SystemAPIClient{}
+		s.On("Ping", mock.Anything).Return(types.Ping{APIVersion: "1.41", OSType: "linux"}, nil)
+		dialer := &mocks.HijackDialer{}
+		dialer.On("DialHijack", mock.Anything, "/session", "h2c", mock.Anything).Return(nil, errors.New("no session"))
+		var buildID string
+		b := &mocks.ImageAPIClient{}
+		b.On("ImageBuild", mock.Anything, mock.Anything, mock.Anything).
+			Once().
+			Run(func(args mock.Arguments) {
+				buildID = args.Get(2).(types.ImageBuildOptions).BuildID
+				cancel()
+			}).
+			Return(types.ImageBuildResponse{}, context.Canceled)
+		b.On("BuildCancel", mock.Anything, mock.Anything).Once().Return(nil)
+
+		d, _ := NewClient(WithImageClient(buildContextReader{b}), WithContainerClient(&mocks.ContainerAPIClient{}), WithSystemClient(s), WithHijackDialer(dialer))
+		_, err := d.BuildImage(ctx, "./testdata", BuildWithTags("ankor:test"))
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.NotEmpty(t, buildID)
+		b.AssertCalled(t, "BuildCancel", mock.Anything, buildID)
+	})
+
+	t.Run("ends the session when the build fails while it is dialling", func(t *testing.T) {
+		s := &mocks.SystemAPIClient{}
+		s.On("Ping", mock.Anything).Return(types.Ping{APIVersion: "1.41", OSType: "linux"}, nil)
+		conn, _ := net.Pipe()
+		dialer := &mocks.HijackDialer{}
+		dialer.On("DialHijack", mock.Anything, "/session", "h2c", mock.Anything).
+			After(50*time.Millisecond).
+			Return(conn, nil)
+		b := &mocks.ImageAPIClient{}
+		b.On("ImageBuild", mock.Anything, mock.Anything, mock.Anything).
+			Once().
+			Return(types.ImageBuildResponse{}, errors.New("build failed"))
+
+		d, _ := NewClient(WithImageClient(buildContextReader{b}), WithContainerClient(&mocks.ContainerAPIClient{}), WithSystemClient(s), WithHijackDialer(dialer))
+		done := make(chan error, 1)
+		go func() {
+			_, err := d.BuildImage(context.Background(), "./testdata", BuildWithTags("ankor:test"))
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			assert.ErrorContains(t, err, "build failed")
+		case <-time.After(3 * time.Second):
+			t.Fatal("BuildImage did not return")
+		}
+	})
+
+	t.Run("falls back to the classic builder", func(t *testing.T) {
+		s := &mocks.SystemAPIClient{}
+		s.On("Ping", mock.Anything).Return(types.Ping{APIVersion: "1.41", OSType: "windows"}, nil)
+		b := &mocks.ImageAPIClient{}
+		b.On("ImageBuild", mock.Anything, mock.Anything, mock.MatchedBy(func(opts types.ImageBuildOptions) bool {
+			return opts.Version == "" && opts.SessionID == ""
+		})).
+			Once().
+			Return(types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(`{"stream": "done"}`))}, nil)
+
+		d, _ := NewClient(WithImageClient(buildContextReader{b}), WithContainerClient(&mocks.ContainerAPIClient{}), WithSystemClient(s), WithHijackDialer(&mocks.HijackDialer{}))
+		_, err := d.BuildImage(context.Background(), "./testdata", BuildWithTags("ankor:test"))
+		assert.NoError(t, err)
+		b.AssertExpectations(t)
+	})
+}
+
+func TestAuthProvider(t *testing.T) {
+	p := &authProvider{auths: map[string]types.AuthConfig{
+		"eu.gcr.io":                   {Username: "oauth2accesstoken", Password: "ya29.token"},
+		"https://index.docker.io/v1/": {IdentityToken: "hub-token"},
+	}}
+
+	resp, err := p.Credentials(context.Background(), &auth.CredentialsRequest{Host: "eu.gcr.io"})
+	assert.NoError(t, err)
+	assert.Equal(t, "oauth2accesstoken", resp.Username)
+	assert.Equal(t, "ya29.token", resp.Secret)
+
+	resp, err = p.Credentials(context.Background(), &auth.CredentialsRequest{Host: "registry-1.docker.io"})
+	assert.NoError(t, err)
+	assert.Equal(t, "", resp.Username)
+	assert.Equal(t, "hub-token", resp.Secret)
+
+	resp, err = p.Credentials(context.Background(), &auth.CredentialsRequest{Host: "ghcr.io"})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Secret)
+}
@@ -0,0 +1,280 @@
+package docker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/go-errors/errors"
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+type ClientOpt func(*Client) error
+
+// HijackDialer opens hijacked connections to the daemon, as used by BuildKit
+// sessions. It is implemented by *client.Client.
+type HijackDialer interface {
+	DialHijack(ctx context.Context, url, proto string, meta map[string][]string) (net.Conn, error)
+}
+
+// Client wraps the docker daemon APIs used by ankor. The connection to the
+// daemon is only established when it is first needed, so creating a Client
+// on a machine without docker is always safe.
+type Client struct {
+	host        string
+	apiVersion  string
+	containers  client.ContainerAPIClient
+	images      client.ImageAPIClient
+	system      client.SystemAPIClient
+	networks    client.NetworkAPIClient
+	volumes     client.VolumeAPIClient
+	dialer      HijackDialer
+	auth        authn.Authenticator
+	authHelpers map[string]AuthHelperFactory
+	// dockerConfigDir holds the docker CLI config.json
+	dockerConfigDir string
+	invocation      string
+	command         string
+	version         string
+	buildKit        bool
+	// lockFile pins the images of runs and pulls, loaded into lock on use
+	lockFile string
+	lock     *Lock
+	// progress rendering of pulls, pushes and builds
+	progressMode     ProgressMode
+	progressOut      io.Writer
+	progressInterval time.Duration
+	progressFunc     ProgressFunc
+	mu               sync.Mutex
+}
+
+// NewClient creates a Client configured by the supplied options.
+func NewClient(opts ...ClientOpt) (*Client, error) {
+	invocation, err := newInvocationID()
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+	c := &Client{
+		authHelpers: defaultAuthHelpers(),
+		invocation:  invocation,
+		command:     defaultCommand(),
+		buildKit:    defaultBuildKit(),
+		lockFile:    DefaultLockFile,
+
+		progressMode:     ProgressAuto,
+		progressOut:      os.Stderr,
+		progressInterval: DefaultProgressInterval,
+	}
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+	}
+	return c, nil
+}
+
+// WithHost sets the docker daemon host, e.g. unix:///var/run/docker.sock.
+// When omitted DOCKER_HOST is used.
+func WithHost(host string) ClientOpt {
+	return func(c *Client) error {
+		c.host = host
+		return nil
+	}
+}
+
+// WithAPIVersion pins the docker API version. When omitted the version is
+// negotiated with the daemon.
+func WithAPIVersion(version string) ClientOpt {
+	return func(c *Client) error {
+		c.apiVersion = version
+		return nil
+	}
+}
+
+// WithContainerClient injects the client used for container operations.
+func WithContainerClient(containers client.ContainerAPIClient) ClientOpt {
+	return func(c *Client) error {
+		c.containers = containers
+		return nil
+	}
+}
+
+// WithImageClient injects the client used for image operations.
+func WithImageClient(images client.ImageAPIClient) ClientOpt {
+	return func(c *Client) error {
+		c.images = images
+		return nil
+	}
+}
+
+// WithSystemClient injects the client used to query the daemon.
+func WithSystemClient(system client.SystemAPIClient) ClientOpt {
+	return func(c *Client) error {
+		c.system = system
+		return nil
+	}
+}
+
+// WithNetworkClient injects the client used for network operations.
+func WithNetworkClient(networks client.NetworkAPIClient) ClientOpt {
+	return func(c *Client) error {
+		c.networks = networks
+		return nil
+	}
+}
+
+// WithVolumeClient injects the client used for volume operations.
+func WithVolumeClient(volumes client.VolumeAPIClient) ClientOpt {
+	return func(c *Client) error {
+		c.volumes = volumes
+		return nil
+	}
+}
+
+// WithHijackDialer injects the dialer used to open BuildKit sessions.
+func WithHijackDialer(dialer HijackDialer) ClientOpt {
+	return func(c *Client) error {
+		c.dialer = dialer
+		return nil
+	}
+}
+
+// WithBuildKit sets whether images are built with BuildKit when the daemon
+// supports it. Defaults to true unless DOCKER_BUILDKIT disables it.
+func WithBuildKit(enabled bool) ClientOpt {
+	return func(c *Client) error {
+		c.buildKit = enabled
+		return nil
+	}
+}
+
+// WithAuthenticator injects the authenticator used for gcloud registries.
+func WithAuthenticator(auth authn.Authenticator) ClientOpt {
+	return func(c *Client) error {
+		c.auth = auth
+		return nil
+	}
+}
+
+// WithCommand sets the ankor command recorded on created containers.
+// Defaults to the subcommand path of the current process, see commandPath.
+func WithCommand(command string) ClientOpt {
+	return func(c *Client) error {
+		c.command = command
+		return nil
+	}
+}
+
+// WithVersion sets the ankor version recorded on created containers.
+func WithVersion(version string) ClientOpt {
+	return func(c *Client) error {
+		c.version = version
+		return nil
+	}
+}
+
+func newInvocationID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func defaultCommand() string {
+	return commandPath(os.Args)
+}
+
+// commandPath returns the program name and the arguments preceding the first
+// flag, e.g. "ankor db seed" for ankor db seed --token s3cr3t. Flags and their
+// values are left out as labels can be read by anyone inspecting containers.
+func commandPath(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	path := []string{filepath.Base(args[0])}
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "-") {
+			break
+		}
+		path = append(path, arg)
+	}
+	return strings.Join(path, " ")
+}
+
+func defaultBuildKit() bool {
+	if v, ok := os.LookupEnv("DOCKER_BUILDKIT"); ok {
+		enabled, err := strconv.ParseBool(v)
+		return err != nil || enabled
+	}
+	return true
+}
+
+// connect lazily creates the daemon connection for any API client that has
+// not been injected.
+func (c *Client) connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.containers != nil && c.images != nil && c.system != nil && c.networks != nil && c.volumes != nil && c.dialer != nil {
+		return nil
+	}
+
+	opts := []client.Opt{client.FromEnv}
+	if c.host != "" {
+		opts = append(opts, client.WithHost(c.host))
+	}
+	if c.apiVersion != "" {
+		opts = append(opts, client.WithVersion(c.apiVersion))
+	} else {
+		opts = append(opts, client.WithAPIVersionNegotiation())
+	}
+
+	dockerClient, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return errors.WrapPrefix(err, "error connecting to docker", 0)
+	}
+	if c.containers == nil {
+		c.containers = dockerClient
+	}
+	if c.images == nil {
+		c.images = dockerClient
+	}
+	if c.system == nil {
+		c.system = dockerClient
+	}
+	if c.networks == nil {
+		c.networks = dockerClient
+	}
+	if c.volumes == nil {
+		c.volumes = dockerClient
+	}
+	if c.dialer == nil {
+		c.dialer = dockerClient
+	}
+	return nil
+}
+
+func (c *Client) IsDockerRunning(ctx context.Context) (bool, error) {
+	if err := c.connect(); err != nil {
+		return false, err
+	}
+	_, err := c.containers.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		if strings.Contains(err.Error(), "Cannot connect") || strings.Contains(err.Error(), "connection refused") {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,166 @@
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/go-errors/errors"
+	"github.com/rs/zerolog/log"
+)
+
+var ErrPathTraversal = errors.New("would be written outside of the copy destination")
+
+// CopyToContainer copies the file or directory at srcPath on the host to
+// dstPath in the container, with the same semantics as `docker cp`. File
+// modes are preserved.
+func (c *Client) CopyToContainer(ctx context.Context, containerID, srcPath, dstPath string) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+	log.Debug().Msgf("Copying %s to %s:%s", srcPath, containerID, dstPath)
+
+	dstInfo := archive.CopyInfo{Path: dstPath}
+	dstStat, err := c.containers.ContainerStatPath(ctx, containerID, dstPath)
+	if err == nil && dstStat.Mode&os.ModeSymlink != 0 {
+		linkTarget := dstStat.LinkTarget
+		if !path.IsAbs(linkTarget) {
+			linkTarget = path.Join(path.Dir(dstPath), linkTarget)
+		}
+		dstInfo.Path = linkTarget
+		dstStat, err = c.containers.ContainerStatPath(ctx, containerID, linkTarget)
+	}
+	// a missing destination is created by the copy as long as its parent
+	// directory exists
+	if err == nil {
+		dstInfo.Exists, dstInfo.IsDir = true, dstStat.Mode.IsDir()
+	}
+
+	srcInfo, err := archive.CopyInfoSourcePath(srcPath, false)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	srcArchive, err := archive.TarResource(srcInfo)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer func() { _ = srcArchive.Close() }()
+
+	dstDir, content, err := archive.PrepareArchiveCopy(srcArchive, srcInfo, dstInfo)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer func() { _ = content.Close() }()
+
+	err = c.containers.CopyToContainer(ctx, containerID, dstDir, content, types.CopyToContainerOptions{})
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	return nil
+}
+
+// CopyFromContainer copies the file or directory at srcPath in the
+// container to dstPath on the host, with the same semantics as `docker cp`.
+// File modes are preserved but not ownership, and entries that would be
+// written outside of dstPath fail the copy.
+func (c *Client) CopyFromContainer(ctx context.Context, containerID, srcPath, dstPath string) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+	log.Debug().Msgf("Copying %s:%s to %s", containerID, srcPath, dstPath)
+
+	content, stat, err := c.containers.CopyFromContainer(ctx, containerID, srcPath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer func() { _ = content.Close() }()
+
+	srcInfo := archive.CopyInfo{
+		Path:   srcPath,
+		Exists: true,
+		IsDir:  stat.Mode.IsDir(),
+	}
+	safe := safeArchive(content)
+	defer func() { _ = safe.Close() }()
+	if err := archive.CopyTo(safe, srcInfo, dstPath); err != nil {
+		return errors.Wrap(err, 0)
+	}
+	return nil
+}
+
+// CopyTo copies srcPath on the host into the container, see
+// Client.CopyToContainer.
+func (ct *Container) CopyTo(ctx context.Context, srcPath, dstPath string) error {
+	return ct.client.CopyToContainer(ctx, ct.ID, srcPath, dstPath)
+}
+
+// CopyFrom copies srcPath in the container to the host, see
+// Client.CopyFromContainer.
+func (ct *Container) CopyFrom(ctx context.Context, srcPath, dstPath string) error {
+	return ct.client.CopyFromContainer(ctx, ct.ID, srcPath, dstPath)
+}
+
+// safeArchive passes a tar stream through, failing on entries that escape
+// the archive root and on entries below a symbolic link of the same
+// archive, which would be written wherever the link points to.
+func safeArchive(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		tr := tar.NewReader(r)
+		tw := tar.NewWriter(pw)
+		symlinks := map[string]bool{}
+		err := func() error {
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					return tw.Close()
+				}
+				if err != nil {
+					return err
+				}
+				name, err := checkArchivePath(hdr.Name, symlinks)
+				if err != nil {
+					return err
+				}
+				switch hdr.Typeflag {
+				case tar.TypeSymlink:
+					symlinks[name] = true
+				case tar.TypeLink:
+					if _, err := checkArchivePath(hdr.Linkname, symlinks); err != nil {
+						return err
+					}
+				}
+				if err := tw.WriteHeader(hdr); err != nil {
+					return err
+				}
+				if _, err := io.Copy(tw, tr); err != nil {
+					return err
+				}
+			}
+		}()
+		_ = pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// checkArchivePath returns the cleaned name of an archive entry, failing
+// when it is absolute, leaves the archive root or goes through one of
+// symlinks.
+func checkArchivePath(name string, symlinks map[string]bool) (string, error) {
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", errors.New(fmt.Errorf("'%s' %w", name, ErrPathTraversal))
+	}
+	for dir := path.Dir(clean); dir != "."; dir = path.Dir(dir) {
+		if symlinks[dir] {
+			return "", errors.New(fmt.Errorf("'%s' %w", name, ErrPathTraversal))
+		}
+	}
+	return clean, nil
+}
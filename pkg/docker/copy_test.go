@@ -0,0 +1,183 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ankorstore/ankorstore-cli-modules/pkg/docker/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	"github.com/go-errors/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// tarEntries reads the names and modes of the entries of a tar stream.
+func tarEntries(t *testing.T, r io.Reader) map[string]os.FileMode {
+	entries := map[string]os.FileMode{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		assert.NoError(t, err)
+		entries[hdr.Name] = hdr.FileInfo().Mode()
+	}
+}
+
+type tarEntry struct {
+	header  tar.Header
+	content string
+}
+
+func tarStream(entries ...tarEntry) io.ReadCloser {
+	b := &bytes.Buffer{}
+	w := tar.NewWriter(b)
+	for _, e := range entries {
+		hdr := e.header
+		hdr.Size = int64(len(e.content))
+		_ = w.WriteHeader(&hdr)
+		_, _ = w.Write([]byte(e.content))
+	}
+	_ = w.Close()
+	return io.NopCloser(b)
+}
+
+// copyContentReader reads the archive streamed to CopyToContainer before the
+// mock records the call, as the mock formats its arguments while the archive
+// would otherwise still be written to the pipe.
+type copyContentReader struct {
+	*mocks.ContainerAPIClient
+}
+
+func (c copyContentReader) CopyToContainer(ctx context.Context, containerID, dstPath string, content io.Reader, options types.CopyToContainerOptions) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	return c.ContainerAPIClient.CopyToContainer(ctx, containerID, dstPath, bytes.NewReader(data), options)
+}
+
+func TestCopyToContainer(t *testing.T) {
+	src := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "gen", "bin"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "gen", "bin", "run.sh"), []byte("#!/bin/sh\n"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "gen", "schema.json"), []byte("{}"), 0600))
+
+	t.Run("copies a directory into an existing directory", func(t *testing.T) {
+		var entries map[string]os.FileMode
+		c := &mocks.ContainerAPIClient{}
+		c.On("ContainerStatPath", mock.Anything, "abc123", "/workspace").
+			Once().
+			Return(types.ContainerPathStat{Name: "workspace", Mode: os.ModeDir | 0755}, nil)
+		c.On("CopyToContainer", mock.Anything, "abc123", "/workspace", mock.Anything, types.CopyToContainerOptions{}).
+			Once().
+			Run(func(args mock.Arguments) { entries = tarEntries(t, args.Get(3).(io.Reader)) }).
+			Return(nil)
+
+		d, _ := NewClient(WithContainerClient(copyContentReader{c}), WithImageClient(&mocks.ImageAPIClient{}))
+		assert.NoError(t, d.CopyToContainer(context.Background(), "abc123", filepath.Join(src, "gen"), "/workspace"))
+		assert.Equal(t, os.FileMode(0755), entries["gen/bin/run.sh"])
+		assert.Equal(t, os.FileMode(0600), entries["gen/schema.json"])
+		assert.True(t, entries["gen/"].IsDir())
+		c.AssertExpectations(t)
+	})
+
+	t.Run("renames a file copied to a missing path", func(t *testing.T) {
+		var entries map[string]os.FileMode
+		c := &mocks.ContainerAPIClient{}
+		c.On("ContainerStatPath", mock.Anything, "abc123", "/workspace/api.json").
+			Once().
+			Return(types.ContainerPathStat{}, errdefs.NotFound(errors.New("no such file")))
+		c.On("CopyToContainer", mock.Anything, "abc123", "/workspace", mock.Anything, mock.Anything).
+			Once().
+			Run(func(args mock.Arguments) { entries = tarEntries(t, args.Get(3).(io.Reader)) }).
+			Return(nil)
+
+		d, _ := NewClient(WithContainerClient(copyContentReader{c}), WithImageClient(&mocks.ImageAPIClient{}))
+		assert.NoError(t, d.CopyToContainer(context.Background(), "abc123", filepath.Join(src, "gen", "schema.json"), "/workspace/api.json"))
+		assert.Equal(t, map[string]os.FileMode{"api.json": 0600}, entries)
+	})
+
+	t.Run("with a missing source", func(t *testing.T) {
+		c := &mocks.ContainerAPIClient{}
+		c.On("ContainerStatPath", mock.Anything, "abc123", "/workspace").Once().Return(types.ContainerPathStat{Mode: os.ModeDir}, nil)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(&mocks.ImageAPIClient{}))
+		assert.Error(t, d.CopyToContainer(context.Background(), "abc123", filepath.Join(src, "missing"), "/workspace"))
+		c.AssertNotCalled(t, "CopyToContainer", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestCopyFromContainer(t *testing.T) {
+	copyFrom := func(t *testing.T, dst string, entries ...tarEntry) error {
+		c := &mocks.ContainerAPIClient{}
+		c.On("CopyFromContainer", mock.Anything, "abc123", "/workspace/out").
+			Once().
+			Return(tarStream(entries...), types.ContainerPathStat{Name: "out", Mode: os.ModeDir | 0755}, nil)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(&mocks.ImageAPIClient{}))
+		return d.CopyFromContainer(context.Background(), "abc123", "/workspace/out", dst)
+	}
+
+	t.Run("copies a directory preserving file modes", func(t *testing.T) {
+		dst := filepath.Join(t.TempDir(), "generated")
+		err := copyFrom(t, dst,
+			tarEntry{header: tar.Header{Name: "out/", Typeflag: tar.TypeDir, Mode: 0755}},
+			tarEntry{header: tar.Header{Name: "out/run.sh", Typeflag: tar.TypeReg, Mode: 0755}, content: "#!/bin/sh\n"},
+			tarEntry{header: tar.Header{Name: "out/api.go", Typeflag: tar.TypeReg, Mode: 0644}, content: "package api\n"},
+		)
+		assert.NoError(t, err)
+
+		info, err := os.Stat(filepath.Join(dst, "run.sh"))
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+		content, err := os.ReadFile(filepath.Join(dst, "api.go"))
+		assert.NoError(t, err)
+		assert.Equal(t, "package api\n", string(content))
+	})
+
+	t.Run("refuses entries escaping the destination", func(t *testing.T) {
+		root := t.TempDir()
+		err := copyFrom(t, filepath.Join(root, "generated"),
+			tarEntry{header: tar.Header{Name: "out/", Typeflag: tar.TypeDir, Mode: 0755}},
+			tarEntry{header: tar.Header{Name: "out/../../evil", Typeflag: tar.TypeReg, Mode: 0644}, content: "x"},
+		)
+		assert.ErrorIs(t, err, ErrPathTraversal)
+		assert.NoFileExists(t, filepath.Join(root, "evil"))
+	})
+
+	t.Run("refuses entries written through a symbolic link", func(t *testing.T) {
+		outside := t.TempDir()
+		err := copyFrom(t, filepath.Join(t.TempDir(), "generated"),
+			tarEntry{header: tar.Header{Name: "out/", Typeflag: tar.TypeDir, Mode: 0755}},
+			tarEntry{header: tar.Header{Name: "out/link", Typeflag: tar.TypeSymlink, Linkname: outside}},
+			tarEntry{header: tar.Header{Name: "out/link/evil", Typeflag: tar.TypeReg, Mode: 0644}, content: "x"},
+		)
+		assert.ErrorIs(t, err, ErrPathTraversal)
+		assert.NoFileExists(t, filepath.Join(outside, "evil"))
+	})
+}
+
+func TestCheckArchivePath(t *testing.T) {
+	symlinks := map[string]bool{"out/link": true}
+	for name, ok := range map[string]bool{
+		"out/a.go":        true,
+		"out/sub/../b.go": true,
+		"out/linked.go":   true,
+		"/etc/passwd":     false,
+		"../evil":         false,
+		"out/../../evil":  false,
+		"out/link/evil":   false,
+		"out/link/a/evil": false,
+	} {
+		_, err := checkArchivePath(name, symlinks)
+		assert.Equal(t, ok, err == nil, name)
+	}
+}
@@ -0,0 +1,273 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+	"github.com/go-errors/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// healthPollInterval is how often WaitHealthy checks on a container.
+var healthPollInterval = 500 * time.Millisecond
+
+// Container is a handle on a container started by RunDetached.
+type Container struct {
+	ID   string
+	Name string
+
+	client       *Client
+	tty          bool
+	probe        HealthProbe
+	exitLogLines int
+}
+
+// HealthProbe checks whether a running container is ready to be used.
+type HealthProbe interface {
+	Ready(ctx context.Context, ct *Container, info types.ContainerJSON) (bool, error)
+}
+
+// RunDetached starts a container in the background and returns a handle to
+// wait for it to be healthy, read its logs, stop and remove it. Unlike Run,
+// the container is kept once it exits unless RunWithAutoRemove(true) is
+// declared.
+func (c *Client) RunDetached(ctx context.Context, opts ...RunOpt) (*Container, error) {
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+
+	runConfig, err := newRunConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, option := range []string{"Output", "Stdin"} {
+		if runConfig.declarations[option] {
+			return nil, conflictError(option, "Detached")
+		}
+	}
+	initRunConfig(runConfig)
+	initRunHostConfig(runConfig)
+	runConfig.Config.Labels = c.withLabels(runConfig.Config.Labels)
+	runConfig.HostConfig.AutoRemove = runConfig.AutoRemove != nil && *runConfig.AutoRemove
+
+	resp, name, err := c.createContainer(ctx, runConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.containers.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		c.removeContainer(resp.ID)
+		return nil, errors.Wrap(err, 0)
+	}
+
+	return &Container{
+		ID:           resp.ID,
+		Name:         name,
+		client:       c,
+		tty:          runConfig.Config.Tty,
+		probe:        runConfig.HealthProbe,
+		exitLogLines: runConfig.exitLogLines(),
+	}, nil
+}
+
+func (ct *Container) String() string {
+	if ct.Name != "" {
+		return ct.Name
+	}
+	return ct.ID
+}
+
+// WaitHealthy blocks until the container is ready. Readiness is decided by
+// the probe declared with RunWithHealthProbe, then by the HEALTHCHECK of
+// the container, and otherwise by the container running. A container that
+// exits or becomes unhealthy fails the wait.
+func (ct *Container) WaitHealthy(ctx context.Context) error {
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
+
+	for {
+		ready, err := ct.ready(ctx)
+		if err != nil {
+			return err
+		}
+		if ready {
+			log.Debug().Msgf("Container %s is healthy", ct)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.WrapPrefix(ctx.Err(), fmt.Sprintf("waiting for container %s to be healthy", ct), 0)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (ct *Container) ready(ctx context.Context) (bool, error) {
+	info, err := ct.client.containers.ContainerInspect(ctx, ct.ID)
+	if err != nil {
+		return false, errors.Wrap(err, 0)
+	}
+	if info.ContainerJSONBase == nil || info.State == nil {
+		return false, nil
+	}
+
+	state := info.State
+	if !state.Running {
+		if state.Status == "exited" || state.Status == "dead" {
+			return false, errors.Wrap(&ContainerExitError{
+				StatusCode:    int64(state.ExitCode),
+				ContainerID:   ct.ID,
+				ContainerName: ct.Name,
+				Logs:          ct.tail(ctx),
+			}, 0)
+		}
+		return false, nil
+	}
+
+	if ct.probe != nil {
+		return ct.probe.Ready(ctx, ct, info)
+	}
+	if state.Health != nil {
+		switch state.Health.Status {
+		case types.Healthy:
+			return true, nil
+		case types.Unhealthy:
+			var output string
+			if n := len(state.Health.Log); n > 0 {
+				output = strings.TrimSpace(state.Health.Log[n-1].Output)
+			}
+			return false, errors.New(fmt.Errorf("container %s is unhealthy: %s", ct, output))
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// tail returns the last lines of output of the container.
+func (ct *Container) tail(ctx context.Context) []string {
+	tail := newTailBuffer(ct.exitLogLines)
+	if err := ct.logs(ctx, tail, tail, false, strconv.Itoa(ct.exitLogLines)); err != nil {
+		log.Debug().Err(err).Msgf("Could not read the logs of container %s", ct)
+	}
+	return tail.Lines()
+}
+
+// Logs copies the output of the container to stdout and stderr. When
+// follow is set it keeps copying until the container stops or ctx is done.
+func (ct *Container) Logs(ctx context.Context, stdout, stderr io.Writer, follow bool) error {
+	return ct.logs(ctx, stdout, stderr, follow, "all")
+}
+
+func (ct *Container) logs(ctx context.Context, stdout, stderr io.Writer, follow bool, tail string) error {
+	reader, err := ct.client.containers.ContainerLogs(ctx, ct.ID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+		Tail:       tail,
+	})
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer func() { _ = reader.Close() }()
+
+	if ct.tty {
+		_, err = io.Copy(stdout, reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, reader)
+	}
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	return nil
+}
+
+// Stop stops the container, killing it once timeout has elapsed.
+func (ct *Container) Stop(ctx context.Context, timeout time.Duration) error {
+	if err := ct.client.containers.ContainerStop(ctx, ct.ID, &timeout); err != nil {
+		return errors.Wrap(err, 0)
+	}
+	return nil
+}
+
+// Remove removes the container together with its anonymous volumes,
+// killing it if it still runs.
+func (ct *Container) Remove(ctx context.Context) error {
+	err := ct.client.containers.ContainerRemove(ctx, ct.ID, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true})
+	if err != nil && !client.IsErrNotFound(err) {
+		return errors.Wrap(err, 0)
+	}
+	return nil
+}
+
+type tcpProbe struct {
+	port nat.Port
+}
+
+// TCPProbe considers a container ready once it accepts connections on port,
+// e.g. "5432". The port must be published with RunWithPorts, as container
+// addresses cannot be reached from the host on Docker Desktop.
+func TCPProbe(port string) HealthProbe {
+	return &tcpProbe{port: tcpPort(port)}
+}
+
+func tcpPort(port string) nat.Port {
+	return nat.Port(strings.TrimSuffix(port, "/tcp") + "/tcp")
+}
+
+func (p *tcpProbe) Ready(ctx context.Context, _ *Container, info types.ContainerJSON) (bool, error) {
+	addr := tcpProbeAddress(p.port, info)
+	if addr == "" {
+		return false, nil
+	}
+	dialer := net.Dialer{Timeout: time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return false, nil
+	}
+	_ = conn.Close()
+	return true, nil
+}
+
+func tcpProbeAddress(port nat.Port, info types.ContainerJSON) string {
+	settings := info.NetworkSettings
+	if settings == nil {
+		return ""
+	}
+	for _, binding := range settings.Ports[port] {
+		host := binding.HostIP
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "127.0.0.1"
+		}
+		return net.JoinHostPort(host, binding.HostPort)
+	}
+	return ""
+}
+
+type logProbe struct {
+	pattern *regexp.Regexp
+}
+
+// LogProbe considers a container ready once its output matches pattern,
+// e.g. "ready to accept connections".
+func LogProbe(pattern *regexp.Regexp) HealthProbe {
+	return &logProbe{pattern: pattern}
+}
+
+func (p *logProbe) Ready(ctx context.Context, ct *Container, _ types.ContainerJSON) (bool, error) {
+	output := &bytes.Buffer{}
+	if err := ct.logs(ctx, output, output, false, "all"); err != nil {
+		return false, err
+	}
+	return p.pattern.Match(output.Bytes()), nil
+}
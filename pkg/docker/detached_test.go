@@ -0,0 +1,197 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/ankorstore/ankorstore-cli-modules/pkg/docker/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	"github.com/go-errors/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func containerState(state *types.ContainerState) types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{Name: "/ankor_test", State: state},
+		NetworkSettings:   &types.NetworkSettings{},
+	}
+}
+
+func detachedMock() *mocks.ContainerAPIClient {
+	c := &mocks.ContainerAPIClient{}
+	c.On("ContainerCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Once().
+		Return(container.ContainerCreateCreatedBody{ID: "abc123"}, nil)
+	c.On("ContainerInspect", mock.Anything, "abc123").
+		Once().
+		Return(types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{Name: "/ankor_test"}}, nil)
+	c.On("ContainerStart", mock.Anything, "abc123", mock.Anything).Once().Return(nil)
+	return c
+}
+
+func runDetached(t *testing.T, c *mocks.ContainerAPIClient, opts ...RunOpt) *Container {
+	d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()))
+	ct, err := d.RunDetached(context.Background(), append([]RunOpt{RunWithImage("postgres")}, opts...)...)
+	assert.NoError(t, err)
+	return ct
+}
+
+func TestRunDetached(t *testing.T) {
+	healthPollInterval = time.Millisecond
+
+	t.Run("starts the container and keeps it once it exits", func(t *testing.T) {
+		c := &mocks.ContainerAPIClient{}
+		c.On("ContainerCreate", mock.Anything, mock.Anything, mock.MatchedBy(func(hc *container.HostConfig) bool {
+			return !hc.AutoRemove
+		}), mock.Anything, mock.Anything, "db").
+			Once().
+			Return(container.ContainerCreateCreatedBody{ID: "abc123"}, nil)
+		c.On("ContainerStart", mock.Anything, "abc123", mock.Anything).Once().Return(nil)
+
+		ct := runDetached(t, c, RunWithName("db"))
+		assert.Equal(t, "abc123", ct.ID)
+		assert.Equal(t, "db", ct.Name)
+		c.AssertExpectations(t)
+	})
+
+	t.Run("removes the container when it cannot start", func(t *testing.T) {
+		c := &mocks.ContainerAPIClient{}
+		c.On("ContainerCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Once().
+			Return(container.ContainerCreateCreatedBody{ID: "abc123"}, nil)
+		c.On("ContainerInspect", mock.Anything, "abc123").Once().Return(types.ContainerJSON{}, errors.New("no inspect"))
+		c.On("ContainerStart", mock.Anything, "abc123", mock.Anything).Once().Return(errors.New("port is already allocated"))
+		c.On("ContainerRemove", mock.Anything, "abc123", mock.Anything).Once().Return(nil)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()))
+		_, err := d.RunDetached(context.Background(), RunWithImage("postgres"))
+		assert.ErrorContains(t, err, "port is already allocated")
+		c.AssertExpectations(t)
+	})
+
+	t.Run("cannot stream output", func(t *testing.T) {
+		d, _ := NewClient(WithContainerClient(&mocks.ContainerAPIClient{}), WithImageClient(presentImages()))
+		_, err := d.RunDetached(context.Background(), RunWithImage("postgres"), RunWithOutput(io.Discard, io.Discard))
+		assert.ErrorIs(t, err, ErrConflict)
+	})
+
+	t.Run("waits for the container healthcheck", func(t *testing.T) {
+		c := detachedMock()
+		for _, status := range []string{types.Starting, types.Starting, types.Healthy} {
+			c.On("ContainerInspect", mock.Anything, "abc123").
+				Once().
+				Return(containerState(&types.ContainerState{Running: true, Health: &types.Health{Status: status}}), nil)
+		}
+
+		ct := runDetached(t, c)
+		assert.NoError(t, ct.WaitHealthy(context.Background()))
+		c.AssertExpectations(t)
+	})
+
+	t.Run("fails when the container is unhealthy", func(t *testing.T) {
+		c := detachedMock()
+		c.On("ContainerInspect", mock.Anything, "abc123").
+			Once().
+			Return(containerState(&types.ContainerState{Running: true, Health: &types.Health{
+				Status: types.Unhealthy,
+				Log:    []*types.HealthcheckResult{{Output: "connection refused\n"}},
+			}}), nil)
+
+		ct := runDetached(t, c)
+		assert.EqualError(t, ct.WaitHealthy(context.Background()), "container ankor_test is unhealthy: connection refused")
+	})
+
+	t.Run("fails with the logs of an exited container", func(t *testing.T) {
+		c := detachedMock()
+		c.On("ContainerInspect", mock.Anything, "abc123").
+			Once().
+			Return(containerState(&types.ContainerState{Status: "exited", ExitCode: 3}), nil)
+		c.On("ContainerLogs", mock.Anything, "abc123", mock.MatchedBy(func(opts types.ContainerLogsOptions) bool {
+			return opts.Tail == "20" && !opts.Follow
+		})).
+			Once().
+			Return(io.NopCloser(multiplexed("starting\n", "FATAL: bad config\n")), nil)
+
+		ct := runDetached(t, c)
+		err := ct.WaitHealthy(context.Background())
+		var exitErr *ContainerExitError
+		assert.True(t, errors.As(err, &exitErr))
+		assert.Equal(t, int64(3), exitErr.StatusCode)
+		assert.Equal(t, []string{"starting", "FATAL: bad config"}, exitErr.Logs)
+	})
+
+	t.Run("stops waiting when the context is done", func(t *testing.T) {
+		c := detachedMock()
+		c.On("ContainerInspect", mock.Anything, "abc123").
+			Return(containerState(&types.ContainerState{Status: "created"}), nil)
+
+		ct := runDetached(t, c)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, ct.WaitHealthy(ctx), context.DeadlineExceeded)
+	})
+
+	t.Run("probes a published tcp port", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		defer func() { _ = listener.Close() }()
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+		info := containerState(&types.ContainerState{Running: true})
+		info.NetworkSettings.Ports = nat.PortMap{"5432/tcp": {{HostIP: "0.0.0.0", HostPort: port}}}
+		c := detachedMock()
+		c.On("ContainerInspect", mock.Anything, "abc123").Once().Return(containerState(&types.ContainerState{Running: true}), nil)
+		c.On("ContainerInspect", mock.Anything, "abc123").Once().Return(info, nil)
+
+		ct := runDetached(t, c, RunWithPorts("5432"), RunWithHealthProbe(TCPProbe("5432")))
+		assert.NoError(t, ct.WaitHealthy(context.Background()))
+		c.AssertExpectations(t)
+	})
+
+	t.Run("probes the container logs", func(t *testing.T) {
+		c := detachedMock()
+		c.On("ContainerInspect", mock.Anything, "abc123").Return(containerState(&types.ContainerState{Running: true}), nil)
+		c.On("ContainerLogs", mock.Anything, "abc123", mock.Anything).
+			Once().
+			Return(io.NopCloser(multiplexed("initializing\n", "")), nil)
+		c.On("ContainerLogs", mock.Anything, "abc123", mock.Anything).
+			Once().
+			Return(io.NopCloser(multiplexed("initializing\n", "database system is ready to accept connections\n")), nil)
+
+		ct := runDetached(t, c, RunWithHealthProbe(LogProbe(regexp.MustCompile("ready to accept connections"))))
+		assert.NoError(t, ct.WaitHealthy(context.Background()))
+		c.AssertExpectations(t)
+	})
+
+	t.Run("stops and removes the container", func(t *testing.T) {
+		c := detachedMock()
+		c.On("ContainerStop", mock.Anything, "abc123", mock.MatchedBy(func(timeout *time.Duration) bool {
+			return *timeout == 5*time.Second
+		})).Once().Return(nil)
+		c.On("ContainerRemove", mock.Anything, "abc123", types.ContainerRemoveOptions{Force: true, RemoveVolumes: true}).
+			Once().
+			Return(errdefs.NotFound(errors.New("no such container")))
+
+		ct := runDetached(t, c)
+		assert.NoError(t, ct.Stop(context.Background(), 5*time.Second))
+		assert.NoError(t, ct.Remove(context.Background()))
+		c.AssertExpectations(t)
+	})
+}
+
+func TestTCPProbeAddress(t *testing.T) {
+	info := containerState(&types.ContainerState{Running: true})
+	info.NetworkSettings.IPAddress = "172.17.0.2"
+	assert.Equal(t, "", tcpProbeAddress("6379/tcp", info), "container addresses are not reachable on Docker Desktop")
+
+	info.NetworkSettings.Ports = nat.PortMap{"6379/tcp": {{HostIP: "127.0.0.2", HostPort: "49153"}}}
+	assert.Equal(t, "127.0.0.2:49153", tcpProbeAddress("6379/tcp", info))
+}
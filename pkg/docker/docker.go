@@ -1,268 +1,534 @@
 package docker
 
 import (
-	"bufio"
 	"context"
-	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"github.com/ankorstore/ankorstore-cli-modules/pkg/errorhandling"
 	"io"
-	"math/big"
-	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/go-units"
 	"github.com/go-errors/errors"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/v1/google"
-	"github.com/jhoonb/archivex"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 )
 
 const (
-	StatusOutput OutputKey  = "status"
-	StreamOutput OutputKey  = "stream"
-	Gcloud       AuthHelper = "gcloud"
-)
-
-var (
-	containers client.ContainerAPIClient
-	builder    client.ImageAPIClient
-	ctx        context.Context
-	auth       authn.Authenticator
+	StatusOutput OutputKey = "status"
+	StreamOutput OutputKey = "stream"
 )
 
 type OutputKey string
-type AuthHelper = string
 
-func init() {
-	var err error
-	ctx = context.Background()
+func (c *Client) getGcloudAuthConfig() (*authn.AuthConfig, error) {
+	if c.auth == nil {
+		auth, err := google.NewGcloudAuthenticator()
+		if err != nil {
+			return &authn.AuthConfig{}, errors.WrapPrefix(err, "error configuring gcloud authenticator", 0)
+		}
+		c.auth = auth
+	}
 
-	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	errorhandling.CheckFatal(err, "X Error connecting to docker ")
-	containers = dockerClient
-	builder = dockerClient
+	authConfig, err := c.auth.Authorization()
+	if err != nil {
+		return &authn.AuthConfig{}, err
+	}
+	return authConfig, nil
 }
 
-func IsDockerRunning() (bool, error) {
-	_, err := containers.ContainerList(context.Background(), types.ContainerListOptions{})
+// PullImage pulls image using the credentials configured for its registry,
+// at the digest pinned by the lock file when there is one.
+func (c *Client) PullImage(ctx context.Context, image string) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+	image, err := c.pinImage(image)
 	if err != nil {
-		if strings.Contains(err.Error(), "Cannot connect") || strings.Contains(err.Error(), "connection refused") {
-			return false, nil
-		}
-		return false, err
+		return err
 	}
-	return true, nil
+	return c.pullImage(ctx, image, "")
 }
 
-func getGcloudAuthConfig() (*authn.AuthConfig, error) {
-	if auth == nil {
-		var err error
-		auth, err = google.NewGcloudAuthenticator()
-		errorhandling.CheckFatal(err, "X Error configuring gcloud authenticator ")
+// pullImage pulls image for platform, or the platform of the daemon when
+// empty.
+func (c *Client) pullImage(ctx context.Context, image, platform string) error {
+	authStr, err := c.registryAuth(image)
+	if err != nil {
+		return err
 	}
+	var options = types.ImagePullOptions{RegistryAuth: authStr, Platform: platform}
 
-	authConfig, err := auth.Authorization()
+	response, err := c.images.ImagePull(ctx, image, options)
 	if err != nil {
-		return &authn.AuthConfig{}, err
+		return errors.Wrap(err, 0)
 	}
-	return authConfig, nil
+	defer func() { _ = response.Close() }()
+
+	p := c.newProgress("Pulling " + image)
+	defer p.close()
+	_, err = printOutput(response, StatusOutput, p)
+	return err
 }
 
-func getGcloudAuthString() (string, error) {
-	authConfig, err := getGcloudAuthConfig()
+// ensureImage applies the pull policy of a run, pulling the image when the
+// policy requires it.
+func (c *Client) ensureImage(ctx context.Context, cfg *RunConfig) error {
+	image := cfg.Config.Image
+	switch cfg.pullPolicy() {
+	case PullNever:
+		return nil
+	case PullIfNotPresent:
+		_, _, err := c.images.ImageInspectWithRaw(ctx, image)
+		if err == nil {
+			log.Debug().Msgf("Image %s is present, not pulling it", image)
+			return nil
+		}
+		if !client.IsErrNotFound(err) {
+			return errors.Wrap(err, 0)
+		}
+		log.Info().Str("image", image).Msg("Image is not present, pulling it")
+	case PullAlways:
+		log.Info().Str("image", image).Msg("Pulling image")
+	}
+
+	var platform string
+	if cfg.Platform != nil {
+		platform = cfg.Platform.OS + "/" + cfg.Platform.Architecture
+		if cfg.Platform.Variant != "" {
+			platform += "/" + cfg.Platform.Variant
+		}
+	}
+	if err := c.pullImage(ctx, image, platform); err != nil {
+		return errors.WrapPrefix(err, "error pulling "+image, 0)
+	}
+	return nil
+}
+
+// PushImage pushes an image to its registry using the credentials configured
+// for that registry. A reference without a tag pushes the latest tag.
+func (c *Client) PushImage(ctx context.Context, ref string) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+
+	named, err := reference.ParseNormalizedNamed(ref)
 	if err != nil {
-		return "", err
+		return errors.Wrap(err, 0)
 	}
+	named = reference.TagNameOnly(named)
 
-	encodedJSON, err := json.Marshal(authConfig)
+	authStr, err := c.registryAuth(named.String())
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	authStr := base64.URLEncoding.EncodeToString(encodedJSON)
-	return authStr, nil
+	log.Info().Str("image", reference.FamiliarString(named)).Msg("Pushing image")
+	response, err := c.images.ImagePush(ctx, reference.FamiliarString(named), types.ImagePushOptions{RegistryAuth: authStr})
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer func() { _ = response.Close() }()
+
+	p := c.newProgress("Pushing " + reference.FamiliarString(named))
+	defer p.close()
+	_, err = printOutput(response, StatusOutput, p)
+	return err
 }
 
-func PullImage(image string) error {
-	var options = types.ImagePullOptions{}
-
-	if viper.InConfig("docker.authentication") {
-		for _, a := range viper.GetStringSlice("docker.authentication") {
-			switch a {
-			case Gcloud:
-				authStr, err := getGcloudAuthString()
-				if err != nil {
-					return errors.Wrap(err, 0)
-				}
-				options.RegistryAuth = authStr
+// PushImages pushes each of the supplied references, stopping at the first
+// failure.
+func (c *Client) PushImages(ctx context.Context, refs ...string) error {
+	for _, ref := range refs {
+		if err := c.PushImage(ctx, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAuthConfig resolves the credentials of every registry served by the
+// helpers enabled in docker.authentication or listed in docker.registries,
+// keyed by registry host. Registry entries take precedence, and the
+// credentials of the docker CLI are used for any other registry. The
+// registries of refs are also resolved when an enabled helper matches them.
+func (c *Client) GetAuthConfig(refs ...string) (map[string]types.AuthConfig, error) {
+	var authConfig = c.dockerConfigAuths()
+	var matchers []AuthHelper
+	for _, name := range viper.GetStringSlice("docker.authentication") {
+		helper, err := c.newAuthHelper(Registry{Helper: name})
+		if err != nil {
+			return authConfig, err
+		}
+		for _, host := range helper.Hosts() {
+			ac, err := helper.AuthConfig(host)
+			if err != nil {
+				return authConfig, err
 			}
+			authConfig[host] = ac
+		}
+		if _, ok := helper.(HostMatcher); ok {
+			matchers = append(matchers, helper)
 		}
 	}
 
-	response, err := builder.ImagePull(ctx, image, options)
+	regs, err := registries()
 	if err != nil {
-		return errors.Wrap(err, 0)
+		return authConfig, err
+	}
+	for _, r := range regs {
+		helper, err := c.newAuthHelper(r)
+		if err != nil {
+			return authConfig, errors.WrapPrefix(err, r.Host, 0)
+		}
+		ac, err := helper.AuthConfig(r.Host)
+		if err != nil {
+			return authConfig, errors.WrapPrefix(err, r.Host, 0)
+		}
+		authConfig[r.Host] = ac
 	}
-	return printOutput(response, StatusOutput)
-}
 
-func GetAuthConfig() (map[string]types.AuthConfig, error) {
-	var authConfig = map[string]types.AuthConfig{}
-	if viper.InConfig("docker.authentication") {
-		for _, a := range viper.GetStringSlice("docker.authentication") {
-			switch a {
-			case Gcloud:
-				authData, err := getGcloudAuthConfig()
-				if err != nil {
-					return authConfig, err
-				}
-				var authc = types.AuthConfig{
-					Username: "oauth2accesstoken",
-					Password: authData.Password,
-				}
-
-				authConfig["eu.gcr.io"] = authc
-				authConfig["gcr.io"] = authc
-				authConfig["asia.gcr.io"] = authc
-				authConfig["eu.gcr.io"] = authc
-				authConfig["gcr.io"] = authc
-				authConfig["marketplace.gcr.io"] = authc
-				authConfig["staging-k8s.gcr.io"] = authc
-				authConfig["us.gcr.io"] = authc
+	for _, ref := range refs {
+		host, err := registryHost(ref)
+		if err != nil {
+			return authConfig, err
+		}
+		if _, ok := authConfig[host]; ok {
+			continue
+		}
+		for _, helper := range matchers {
+			if !helper.(HostMatcher).Matches(host) {
+				continue
 			}
+			ac, err := helper.AuthConfig(host)
+			if err != nil {
+				return authConfig, err
+			}
+			authConfig[host] = ac
+			break
 		}
 	}
 	return authConfig, nil
 }
 
-func BuildImage(tag, path, dockerfile string) error {
-	log.Debug().Msgf("Running the equivalent of `docker build -t %s -f %s/%s %s", tag, path, dockerfile, path)
+// BuildImage builds the image described by the options from the context at
+// path and returns its ID.
+func (c *Client) BuildImage(ctx context.Context, path string, opts ...BuildOpt) (string, error) {
+	if err := c.connect(); err != nil {
+		return "", err
+	}
 
-	authMap, err := GetAuthConfig()
+	buildConfig, err := newBuildConfig(opts...)
 	if err != nil {
-		return errors.Wrap(err, 0)
+		return "", err
 	}
+	options := buildConfig.Options
+
+	log.Debug().Msgf("Running the equivalent of `docker build -t %s -f %s %s`",
+		strings.Join(options.Tags, " -t "), options.Dockerfile, path)
 
-	opts := types.ImageBuildOptions{
-		Dockerfile:  dockerfile,
-		Tags:        []string{tag},
-		ForceRemove: true,
-		PullParent:  true,
-		AuthConfigs: authMap,
-		//Version: types.BuilderBuildKit,
+	buildCtx, dockerfile, err := createBuildContext(path, options.Dockerfile)
+	if err != nil {
+		return "", err
 	}
+	defer func() { _ = buildCtx.Close() }()
 
-	ctxFile, err := createContextFile(path)
+	if !filepath.IsAbs(options.Dockerfile) {
+		options.Dockerfile = filepath.Join(path, options.Dockerfile)
+	}
+	baseImages, err := dockerfileImages(options.Dockerfile)
 	if err != nil {
-		return errors.Wrap(err, 0)
+		return "", err
 	}
-	defer func() { _ = ctxFile.Close() }()
+	options.AuthConfigs, err = c.GetAuthConfig(baseImages...)
+	if err != nil {
+		return "", errors.Wrap(err, 0)
+	}
+	options.Dockerfile = dockerfile
 
-	response, err := builder.ImageBuild(context.TODO(), ctxFile, opts)
+	title := "Building " + path
+	if len(options.Tags) > 0 {
+		title = "Building " + options.Tags[0]
+	}
+	p := c.newProgress(title)
+	imageID, err := c.build(ctx, buildCtx, options, buildConfig.Secrets, p)
+	p.close()
 	if err != nil {
-		return errors.Wrap(err, 0)
+		return "", err
 	}
-	defer func() { _ = response.Body.Close() }()
+	log.Debug().Msgf("Built image %s", imageID)
 
-	return printOutput(response.Body, StreamOutput)
+	if buildConfig.Push {
+		return imageID, c.PushImages(ctx, options.Tags...)
+	}
+	return imageID, nil
 }
 
-func createContextFile(ctx string) (*os.File, error) {
-	randInt, err := rand.Int(rand.Reader, big.NewInt(27))
+func (c *Client) build(ctx context.Context, buildCtx io.Reader, options types.ImageBuildOptions, secrets []secretsprovider.Source, p *progress) (string, error) {
+	if c.supportsBuildKit(ctx) {
+		return c.buildWithBuildKit(ctx, buildCtx, options, secrets, p)
+	}
+	if len(secrets) > 0 {
+		return "", errors.New("build secrets require BuildKit, which is disabled or not supported by the docker daemon")
+	}
+
+	response, err := c.images.ImageBuild(ctx, buildCtx, options)
 	if err != nil {
-		return nil, err
-	}
-	filename := fmt.Sprintf("/tmp/ankor-%d.tar", randInt)
-	tar := new(archivex.TarFile)
-	_ = tar.Create(filename)
-	_ = tar.AddAll(ctx, false)
-	_ = tar.Close()
-	return os.Open(filename)
+		return "", errors.Wrap(err, 0)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	return printOutput(response.Body, StreamOutput, p)
 }
 
-func printOutput(reader io.Reader, key OutputKey) error {
-	type OutputLine struct {
-		Stream      string `json:"stream"`
-		Status      string `json:"status"`
-		ErrorDetail struct {
-			Message string `json:"message"`
-		} `json:"errorDetail"`
-	}
-
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		var line OutputLine
-		t := scanner.Text()
-		errorhandling.CheckError(json.Unmarshal([]byte(t), &line))
-		var out []string
+// printOutput logs the messages of a daemon JSON stream, rendering them on p,
+// and returns the error reported in the stream, if any, together with the
+// ID of the image built when the stream comes from a build.
+func printOutput(reader io.Reader, key OutputKey, p *progress) (string, error) {
+	var imageID string
+	decoder := json.NewDecoder(reader)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return imageID, nil
+			}
+			return imageID, errors.Wrap(err, 0)
+		}
+		if msg.Error != nil {
+			return imageID, errors.New(msg.Error)
+		}
+		if msg.Aux != nil {
+			var result types.BuildResult
+			if err := json.Unmarshal(*msg.Aux, &result); err == nil && result.ID != "" {
+				imageID = result.ID
+			}
+			continue
+		}
+		p.update(msg)
+
+		var out string
 		switch key {
 		case StreamOutput:
-			out = strings.Split(line.Stream, "\n")
+			out = msg.Stream
 		case StatusOutput:
-			out = strings.Split(line.Status, "\n")
-		}
-		if len(line.ErrorDetail.Message) > 0 {
-			out = strings.Split(line.ErrorDetail.Message, "\n")
+			out = statusLine(msg)
 		}
-		for _, l := range out {
+		for _, l := range strings.Split(out, "\n") {
 			if len(l) > 0 {
 				log.Debug().Msgf("\t| %s", l)
 			}
 		}
 	}
-	return nil
 }
 
-func Run(opts ...RunOpt) error {
-	runConfig := &RunConfig{}
-	for _, o := range opts {
-		err := o(runConfig)
-		if err != nil {
+// statusLine formats a status message with the layer it refers to and its
+// progress, e.g. "a3ed95caeb02: Downloading 1.2MB/3.4MB".
+func statusLine(msg jsonmessage.JSONMessage) string {
+	line := msg.Status
+	if msg.ID != "" {
+		line = fmt.Sprintf("%s: %s", msg.ID, msg.Status)
+	}
+	if msg.Progress != nil && msg.Progress.Total > 0 {
+		line = fmt.Sprintf("%s %s/%s", line, units.HumanSize(float64(msg.Progress.Current)), units.HumanSize(float64(msg.Progress.Total)))
+	}
+	return line
+}
+
+// Run creates and starts a container, streaming its output until it exits.
+// When ctx is cancelled or the process receives SIGINT or SIGTERM, the
+// container is stopped, killed once its stop timeout has elapsed, and
+// removed unless RunWithAutoRemove(false) was declared.
+func (c *Client) Run(ctx context.Context, opts ...RunOpt) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+
+	runConfig, err := newRunConfig(opts...)
+	if err != nil {
+		return err
+	}
+	initRunConfig(runConfig)
+	initRunHostConfig(runConfig)
+	runConfig.Config.Labels = c.withLabels(runConfig.Config.Labels)
+	runConfig.HostConfig.AutoRemove = runConfig.autoRemove()
+
+	ctx, stopSignals := notifyContext(ctx)
+	defer stopSignals()
+
+	resp, name, err := c.createContainer(ctx, runConfig)
+	if err != nil {
+		return err
+	}
+
+	// AutoRemove only applies once a container has started, so remove it
+	// ourselves if we fail before that
+	started := false
+	defer func() {
+		if !started && runConfig.HostConfig.AutoRemove {
+			c.removeContainer(resp.ID)
+		}
+	}()
+
+	// attach before starting so no output is lost for short-lived containers
+	attach, err := c.containers.ContainerAttach(ctx, resp.ID, types.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  runConfig.Stdin != nil,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer attach.Close()
+
+	tty := runConfig.Config.Tty
+	output, err := runConfig.stream(attach, tty)
+	if err != nil {
+		return err
+	}
+	defer output.restore()
+
+	statusCh, errCh := c.containers.ContainerWait(ctx, resp.ID, container.WaitConditionNextExit)
+	if err := c.containers.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return errors.Wrap(err, 0)
+	}
+	started = true
+	if tty {
+		monitorCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		c.monitorTTYSize(monitorCtx, c.containers.ContainerResize, resp.ID, runConfig.stdout())
+	}
+	var status container.ContainerWaitOKBody
+	select {
+	case <-ctx.Done():
+	case err := <-errCh:
+		if err != nil && ctx.Err() == nil {
 			return errors.Wrap(err, 0)
 		}
+	case status = <-statusCh:
+	}
+	if ctx.Err() != nil {
+		// a second interrupt terminates ankor without waiting for the stop
+		stopSignals()
+		c.interruptContainer(resp.ID, runConfig)
+		return errors.WrapPrefix(ctx.Err(), fmt.Sprintf("container %s interrupted", name), 0)
 	}
+
+	// the attached stream is closed by the daemon once the container exits
+	if err := <-output.done; err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	if status.Error != nil && status.Error.Message != "" {
+		return errors.New(fmt.Errorf("waiting for container %s: %s", resp.ID, status.Error.Message))
+	}
+	if status.StatusCode != 0 {
+		return errors.Wrap(&ContainerExitError{
+			StatusCode:    status.StatusCode,
+			ContainerID:   resp.ID,
+			ContainerName: name,
+			Logs:          output.tail.Lines(),
+		}, 0)
+	}
+
+	return nil
+}
+
+// interruptContainer stops a container whose run was cancelled, the daemon
+// killing it once its stop timeout has elapsed, then removes it unless the
+// run opted out of automatic removal.
+func (c *Client) interruptContainer(id string, runConfig *RunConfig) {
+	timeout := runConfig.stopTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+cleanupTimeout)
+	defer cancel()
+
+	log.Info().Msgf("Stopping container %s", id)
+	if err := c.containers.ContainerStop(ctx, id, &timeout); err != nil {
+		log.Debug().Err(err).Msgf("Could not stop container %s", id)
+	}
+	if runConfig.AutoRemove == nil || *runConfig.AutoRemove {
+		c.removeContainer(id)
+	}
+}
+
+// createContainer pins the image of a run to its locked digest, pulls it as
+// its policy requires and creates the container, returning it together with
+// its name.
+func (c *Client) createContainer(ctx context.Context, runConfig *RunConfig) (container.ContainerCreateCreatedBody, string, error) {
+	image, err := c.pinImage(runConfig.Config.Image)
+	if err != nil {
+		return container.ContainerCreateCreatedBody{}, "", err
+	}
+	runConfig.Config.Image = image
+	if err := c.ensureImage(ctx, runConfig); err != nil {
+		return container.ContainerCreateCreatedBody{}, "", err
+	}
+
 	log.Info().
 		Str("image", runConfig.Config.Image).
 		Str("entrypoint", strings.Join(runConfig.Config.Entrypoint, " ")).
 		Str("cmd", strings.Join(runConfig.Config.Cmd, " ")).
 		Msg("Running container")
 
-	resp, err := containers.ContainerCreate(ctx,
+	resp, err := c.containers.ContainerCreate(ctx,
 		runConfig.Config,
 		runConfig.HostConfig,
 		runConfig.NetworkConfig,
 		runConfig.Platform,
 		runConfig.Name)
 	if err != nil {
-		return errors.Wrap(err, 0)
-	}
-	if err := containers.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
-		return errors.Wrap(err, 0)
+		return resp, "", errors.Wrap(err, 0)
 	}
-	statusCh, errCh := containers.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
-		if err != nil {
-			return errors.Wrap(err, 0)
+
+	name := runConfig.Name
+	if name == "" {
+		if info, err := c.containers.ContainerInspect(ctx, resp.ID); err == nil {
+			name = strings.TrimPrefix(info.Name, "/")
+		} else {
+			log.Debug().Err(err).Msgf("Could not inspect container %s", resp.ID)
 		}
-	case <-statusCh:
 	}
-	out, err := containers.ContainerLogs(ctx, resp.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+	return resp, name, nil
+}
+
+// removeContainer removes a container, even once the context of the
+// operation that created it is done.
+func (c *Client) removeContainer(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	err := c.containers.ContainerRemove(ctx, id, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true})
 	if err != nil {
-		return errors.Wrap(err, 0)
+		log.Debug().Err(err).Msgf("Could not remove container %s", id)
 	}
-	_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, out)
-	if err != nil {
-		return errors.Wrap(err, 0)
+}
+
+// GarbageCollect removes stopped containers created by ankor more than
+// olderThan ago, e.g. those left behind by runs that crashed or opted out of
+// automatic removal.
+func (c *Client) GarbageCollect(ctx context.Context, olderThan time.Duration) (types.ContainersPruneReport, error) {
+	if err := c.connect(); err != nil {
+		return types.ContainersPruneReport{}, err
 	}
 
-	return nil
+	report, err := c.containers.ContainersPrune(ctx, filters.NewArgs(
+		filters.Arg("label", LabelManaged+"=true"),
+		filters.Arg("until", olderThan.String()),
+	))
+	if err != nil {
+		return report, errors.Wrap(err, 0)
+	}
+	log.Debug().Msgf("Removed %d ankor containers, reclaiming %d bytes", len(report.ContainersDeleted), report.SpaceReclaimed)
+	return report, nil
 }
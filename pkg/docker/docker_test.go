@@ -1,12 +1,24 @@
 package docker
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"github.com/ankorstore/ankorstore-cli-modules/pkg/docker/mocks"
 	"io"
+	"net"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/go-errors/errors"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/phpboyscout/zltest"
@@ -17,12 +29,55 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
+func TestNewClient(t *testing.T) {
+	t.Run("does not connect on creation", func(t *testing.T) {
+		d, err := NewClient(WithHost("tcp://127.0.0.1:1"), WithAPIVersion("1.41"))
+		assert.NoError(t, err)
+		assert.Nil(t, d.containers)
+		assert.Nil(t, d.images)
+	})
+
+	t.Run("connects lazily when first needed", func(t *testing.T) {
+		d, err := NewClient(WithHost("tcp://127.0.0.1:1"), WithAPIVersion("1.41"))
+		assert.NoError(t, err)
+		assert.NoError(t, d.connect())
+		assert.NotNil(t, d.containers)
+		assert.NotNil(t, d.images)
+	})
+
+	t.Run("keeps injected clients", func(t *testing.T) {
+		c := &mocks.ContainerAPIClient{}
+		b := &mocks.ImageAPIClient{}
+		d, err := NewClient(WithContainerClient(c), WithImageClient(b))
+		assert.NoError(t, err)
+		assert.NoError(t, d.connect())
+		assert.Same(t, c, d.containers)
+		assert.Same(t, b, d.images)
+	})
+
+	t.Run("returns an error for an invalid host", func(t *testing.T) {
+		d, err := NewClient(WithHost("not a host"))
+		assert.NoError(t, err)
+		running, err := d.IsDockerRunning(context.Background())
+		assert.Error(t, err)
+		assert.False(t, running)
+	})
+}
+
+func TestCommandPath(t *testing.T) {
+	assert.Equal(t, "", commandPath(nil))
+	assert.Equal(t, "ankor", commandPath([]string{"/usr/local/bin/ankor"}))
+	assert.Equal(t, "ankor db seed", commandPath([]string{"/usr/local/bin/ankor", "db", "seed"}))
+	assert.Equal(t, "ankor login", commandPath([]string{"ankor", "login", "--token", "s3cr3t", "registry"}))
+	assert.Equal(t, "ankor run", commandPath([]string{"ankor", "run", "--password=s3cr3t"}))
+}
+
 func TestIsDockerRunning(t *testing.T) {
 	t.Run("with running docker daemon", func(t *testing.T) {
 		c := &mocks.ContainerAPIClient{}
 		c.On("ContainerList", mock.Anything, mock.Anything).Once().Return(nil, nil)
-		containers = c
-		running, err := IsDockerRunning()
+		d, _ := NewClient(WithContainerClient(c))
+		running, err := d.IsDockerRunning(context.Background())
 		assert.NoError(t, err)
 		assert.True(t, running)
 	})
@@ -33,8 +88,8 @@ func TestIsDockerRunning(t *testing.T) {
 			Once().
 			Return(nil,
 				errors.New("random string with 'Cannot connect' in the middle"))
-		containers = c
-		running, err := IsDockerRunning()
+		d, _ := NewClient(WithContainerClient(c))
+		running, err := d.IsDockerRunning(context.Background())
 		assert.NoError(t, err)
 		assert.False(t, running)
 	})
@@ -45,8 +100,8 @@ func TestIsDockerRunning(t *testing.T) {
 			Once().
 			Return(nil,
 				errors.New("random string with 'connection refused' in the middle"))
-		containers = c
-		running, err := IsDockerRunning()
+		d, _ := NewClient(WithContainerClient(c))
+		running, err := d.IsDockerRunning(context.Background())
 		assert.NoError(t, err)
 		assert.False(t, running)
 	})
@@ -57,14 +112,15 @@ func TestIsDockerRunning(t *testing.T) {
 			Once().
 			Return(nil,
 				errors.New("a generic error message"))
-		containers = c
-		running, err := IsDockerRunning()
+		d, _ := NewClient(WithContainerClient(c))
+		running, err := d.IsDockerRunning(context.Background())
 		assert.Error(t, err)
 		assert.False(t, running)
 	})
 }
 
 func TestGetAuthConfig(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
 	t.Run("with valid gcloud authentication", func(t *testing.T) {
 		cfg := `{"docker": { "authentication" : ["gcloud"]}}`
 		viper.Reset()
@@ -78,9 +134,9 @@ func TestGetAuthConfig(t *testing.T) {
 				Password: "ya29.encryptedtoken",
 			}, nil)
 
-		auth = authMock
+		d, _ := NewClient(WithAuthenticator(authMock))
 
-		result, err := GetAuthConfig()
+		result, err := d.GetAuthConfig()
 		assert.NoError(t, err)
 
 		assert.Len(t, result, 6)
@@ -101,27 +157,12 @@ func TestGetAuthConfig(t *testing.T) {
 		authMock.On("Authorization").
 			Return(&authn.AuthConfig{}, errors.New("test error"))
 
-		auth = authMock
+		d, _ := NewClient(WithAuthenticator(authMock))
 
-		_, err := GetAuthConfig()
+		_, err := d.GetAuthConfig()
 		assert.Error(t, err)
 	})
 
-	t.Run("when using the getGcloudAuthString method", func(t *testing.T) {
-		cfg := `{"docker": { "authentication" : ["gcloud"]}}`
-		viper.Reset()
-		viper.SetConfigType("json")
-		_ = viper.ReadConfig(strings.NewReader(cfg))
-
-		authMock := &mocks.Authenticator{}
-		authMock.On("Authorization").
-			Return(&authn.AuthConfig{}, errors.New("test error"))
-
-		auth = authMock
-
-		_, err := getGcloudAuthString()
-		assert.Error(t, err)
-	})
 }
 
 func TestPullImage(t *testing.T) {
@@ -139,8 +180,6 @@ func TestPullImage(t *testing.T) {
 			Password: "ya29.encryptedtoken",
 		}, nil)
 
-	auth = authMock
-
 	t.Run("successful pull", func(t *testing.T) {
 		helper.Reset()
 		ret := io.NopCloser(strings.NewReader(`{"status": "test line of output"}`))
@@ -149,9 +188,9 @@ func TestPullImage(t *testing.T) {
 		b.On("ImagePull", mock.Anything, mock.Anything, mock.Anything).
 			Once().
 			Return(ret, nil)
-		builder = b
+		d, _ := NewClient(WithImageClient(b), WithContainerClient(&mocks.ContainerAPIClient{}), WithAuthenticator(authMock))
 
-		err := PullImage("busybox")
+		err := d.PullImage(context.Background(), "busybox")
 		assert.NoError(t, err)
 
 		helper.Entries().ExpMsg("\t| test line of output")
@@ -165,49 +204,517 @@ func TestPullImage(t *testing.T) {
 		b.On("ImagePull", mock.Anything, mock.Anything, mock.Anything).
 			Once().
 			Return(ret, nil)
-		builder = b
+		d, _ := NewClient(WithImageClient(b), WithContainerClient(&mocks.ContainerAPIClient{}), WithAuthenticator(authMock))
 
-		err := PullImage("busybox")
-		assert.NoError(t, err)
+		err := d.PullImage(context.Background(), "busybox")
+		assert.ErrorContains(t, err, "unexpected EOF")
+	})
+
+	t.Run("denied pull", func(t *testing.T) {
+		ret := io.NopCloser(strings.NewReader(`{"errorDetail": {"message": "pull access denied for ankor"}, "error": "pull access denied for ankor"}`))
 
-		helper.Entries().ExpError("unexpected end of JSON input")
+		b := &mocks.ImageAPIClient{}
+		b.On("ImagePull", mock.Anything, mock.Anything, mock.Anything).
+			Once().
+			Return(ret, nil)
+		d, _ := NewClient(WithImageClient(b), WithContainerClient(&mocks.ContainerAPIClient{}), WithAuthenticator(authMock))
+
+		err := d.PullImage(context.Background(), "ankor")
+		assert.ErrorContains(t, err, "pull access denied")
+	})
+
+	t.Run("only sends the credentials of the image registry", func(t *testing.T) {
+		t.Setenv("DOCKER_CONFIG", t.TempDir())
+		cases := map[string]string{
+			"eu.gcr.io/ankorstore/ankor:v1":                 "ya29.encryptedtoken",
+			"europe-west1-docker.pkg.dev/ankorstore/x/y:v1": "ya29.encryptedtoken",
+			"busybox":                  "",
+			"ghcr.io/ankorstore/ankor": "",
+		}
+		for image, password := range cases {
+			password := password
+			b := &mocks.ImageAPIClient{}
+			b.On("ImagePull", mock.Anything, image, mock.MatchedBy(func(opts types.ImagePullOptions) bool {
+				return decodeAuth(t, opts.RegistryAuth).Password == password
+			})).
+				Once().
+				Return(io.NopCloser(strings.NewReader(`{"status": "done"}`)), nil)
+			d, _ := NewClient(WithImageClient(b), WithContainerClient(&mocks.ContainerAPIClient{}), WithAuthenticator(authMock))
+
+			err := d.PullImage(context.Background(), image)
+			assert.NoError(t, err)
+			b.AssertExpectations(t)
+		}
+	})
+
+	t.Run("does not invoke unrelated helpers", func(t *testing.T) {
+		useConfig(t, `{"docker": {"authentication": ["gcloud"], "registries": [{"host": "ghcr.io", "helper": "static", "username": "ci", "token": "ghp_token"}]}}`)
+		defer viper.Reset()
+		failingAuth := &mocks.Authenticator{}
+
+		b := &mocks.ImageAPIClient{}
+		b.On("ImagePull", mock.Anything, "ghcr.io/ankorstore/ankor", mock.MatchedBy(func(opts types.ImagePullOptions) bool {
+			return decodeAuth(t, opts.RegistryAuth).Password == "ghp_token"
+		})).
+			Once().
+			Return(io.NopCloser(strings.NewReader(`{"status": "done"}`)), nil)
+		b.On("ImagePull", mock.Anything, "busybox", mock.Anything).
+			Once().
+			Return(io.NopCloser(strings.NewReader(`{"status": "done"}`)), nil)
+		d, _ := NewClient(WithImageClient(b), WithContainerClient(&mocks.ContainerAPIClient{}), WithAuthenticator(failingAuth))
+
+		assert.NoError(t, d.PullImage(context.Background(), "ghcr.io/ankorstore/ankor"))
+		assert.NoError(t, d.PullImage(context.Background(), "busybox"))
+		b.AssertExpectations(t)
+		failingAuth.AssertNotCalled(t, "Authorization")
+	})
+
+	t.Run("uses docker CLI credentials for other registries", func(t *testing.T) {
+		useConfig(t, `{"docker": {"authentication": ["gcloud"]}}`)
+		defer viper.Reset()
+		dir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"),
+			[]byte(`{"auths": {"https://index.docker.io/v1/": {"auth": "aHVidXNlcjpodWJwYXNz"}}}`), 0600))
+
+		b := &mocks.ImageAPIClient{}
+		b.On("ImagePull", mock.Anything, "busybox", mock.MatchedBy(func(opts types.ImagePullOptions) bool {
+			return decodeAuth(t, opts.RegistryAuth).Password == "hubpass"
+		})).
+			Once().
+			Return(io.NopCloser(strings.NewReader(`{"status": "done"}`)), nil)
+		d, _ := NewClient(WithImageClient(b), WithContainerClient(&mocks.ContainerAPIClient{}), WithDockerConfigDir(dir))
+
+		assert.NoError(t, d.PullImage(context.Background(), "busybox"))
+		b.AssertExpectations(t)
 	})
 }
 
 func TestPrintOutput(t *testing.T) {
 	helper := zltest.New(t)
 	log.Logger = zerolog.New(helper)
+	l := `
+		{"status": "test line of status output"}
+		{"stream": "test line of\nstream output"}
+		{"status": "Downloading", "id": "a3ed95caeb02", "progressDetail": {"current": 1000000, "total": 3000000}}
+		{"aux": {"ID": "sha256:2f9d53a9e3e1"}}
+	`
+
 	t.Run("status output", func(t *testing.T) {
-		l := `
-			{"status": "test line of status output"}
-			{"stream": "test line of stream output"}
-			{"status": "test line of error output",  "errorDetail": {"message": "there was\nan error"}}
-			{"stream": "test line of\nerror output", "errorDetail": {"message": "there was an error"}}
-		`
-		err := printOutput(strings.NewReader(l), StatusOutput)
+		helper.Reset()
+		_, err := printOutput(strings.NewReader(l), StatusOutput, nil)
 		assert.NoError(t, err)
 
 		helper.Entries().ExpMsg("\t| test line of status output")
-		helper.Entries().ExpMsg("\t| there was")
-		helper.Entries().ExpMsg("\t| an error")
-		helper.Entries().ExpMsg("\t| there was an error")
-		helper.Entries().ExpError("unexpected end of JSON input")
+		helper.Entries().ExpMsg("\t| a3ed95caeb02: Downloading 1MB/3MB")
+		helper.Entries().ExpLen(2)
 	})
 
 	t.Run("stream output", func(t *testing.T) {
-		l := `
-			{"status": "test line of status output"}
-			{"stream": "test line of stream output"}
-			{"status": "test line of error output",  "errorDetail": {"message": "there was\nan error"}}
-			{"stream": "test line of\nerror output", "errorDetail": {"message": "there was an error"}}
-		`
-		err := printOutput(strings.NewReader(l), StreamOutput)
+		helper.Reset()
+		imageID, err := printOutput(strings.NewReader(l), StreamOutput, nil)
 		assert.NoError(t, err)
+		assert.Equal(t, "sha256:2f9d53a9e3e1", imageID)
 
-		helper.Entries().ExpMsg("\t| test line of status output")
-		helper.Entries().ExpMsg("\t| there was")
-		helper.Entries().ExpMsg("\t| an error")
-		helper.Entries().ExpMsg("\t| there was an error")
-		helper.Entries().ExpError("unexpected end of JSON input")
+		helper.Entries().ExpMsg("\t| test line of")
+		helper.Entries().ExpMsg("\t| stream output")
+		helper.Entries().ExpLen(2)
+	})
+
+	t.Run("error output", func(t *testing.T) {
+		helper.Reset()
+		_, err := printOutput(strings.NewReader(`
+			{"stream": "Step 2/2 : RUN false"}
+			{"errorDetail": {"code": 1, "message": "The command '/bin/sh -c false' returned a non-zero code: 1"}, "error": "The command '/bin/sh -c false' returned a non-zero code: 1"}
+			{"stream": "never read"}
+		`), StreamOutput, nil)
+		assert.ErrorContains(t, err, "returned a non-zero code: 1")
+		helper.Entries().ExpMsg("\t| Step 2/2 : RUN false")
+		helper.Entries().ExpLen(1)
+	})
+
+	t.Run("malformed json", func(t *testing.T) {
+		_, err := printOutput(strings.NewReader(`{"status": "test line of )`), StatusOutput, nil)
+		assert.Error(t, err)
+	})
+}
+
+func attachResponse(r io.Reader) types.HijackedResponse {
+	conn, _ := net.Pipe()
+	return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(r)}
+}
+
+func multiplexed(stdout, stderr string) io.Reader {
+	b := &bytes.Buffer{}
+	_, _ = stdcopy.NewStdWriter(b, stdcopy.Stdout).Write([]byte(stdout))
+	_, _ = stdcopy.NewStdWriter(b, stdcopy.Stderr).Write([]byte(stderr))
+	return b
+}
+
+func waitResponse(code int64) (<-chan container.ContainerWaitOKBody, <-chan error) {
+	statusCh := make(chan container.ContainerWaitOKBody, 1)
+	statusCh <- container.ContainerWaitOKBody{StatusCode: code}
+	return statusCh, make(chan error)
+}
+
+type signalWriter struct {
+	bytes.Buffer
+	written chan struct{}
+}
+
+func (w *signalWriter) Write(p []byte) (int, error) {
+	n, err := w.Buffer.Write(p)
+	w.written <- struct{}{}
+	return n, err
+}
+
+// runMock prepares a container client for a single Run of container abc123.
+func runMock(output io.Reader, statusCh <-chan container.ContainerWaitOKBody, errCh <-chan error) *mocks.ContainerAPIClient {
+	c := &mocks.ContainerAPIClient{}
+	c.On("ContainerCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Once().
+		Return(container.ContainerCreateCreatedBody{ID: "abc123"}, nil)
+	c.On("ContainerInspect", mock.Anything, "abc123").
+		Once().
+		Return(types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{Name: "/ankor_test"}}, nil)
+	c.On("ContainerAttach", mock.Anything, "abc123", mock.Anything).
+		Once().
+		Return(attachResponse(output), nil)
+	c.On("ContainerWait", mock.Anything, "abc123", container.WaitConditionNextExit).
+		Once().
+		Return(statusCh, errCh)
+	c.On("ContainerStart", mock.Anything, "abc123", mock.Anything).Once().Return(nil)
+	return c
+}
+
+// presentImages prepares an image client having every image, so runs do not
+// pull them.
+func presentImages() *mocks.ImageAPIClient {
+	i := &mocks.ImageAPIClient{}
+	i.On("ImageInspectWithRaw", mock.Anything, mock.Anything).Return(types.ImageInspect{}, nil, nil)
+	return i
+}
+
+func TestRun(t *testing.T) {
+	t.Run("streams output to the configured writers", func(t *testing.T) {
+		statusCh, errCh := waitResponse(0)
+		c := runMock(multiplexed("to stdout\n", "to stderr\n"), statusCh, errCh)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()))
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		err := d.Run(context.Background(), RunWithImage("busybox"), RunWithOutput(stdout, stderr))
+		assert.NoError(t, err)
+		assert.Equal(t, "to stdout\n", stdout.String())
+		assert.Equal(t, "to stderr\n", stderr.String())
+		c.AssertExpectations(t)
+	})
+
+	t.Run("streams output while the container is running", func(t *testing.T) {
+		r, w := io.Pipe()
+		statusCh := make(chan container.ContainerWaitOKBody, 1)
+		c := runMock(r, statusCh, make(chan error))
+
+		stdout := &signalWriter{written: make(chan struct{}, 1)}
+		go func() {
+			_, _ = stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte("still running\n"))
+			<-stdout.written
+			statusCh <- container.ContainerWaitOKBody{}
+			_ = w.Close()
+		}()
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()))
+		err := d.Run(context.Background(), RunWithImage("busybox"), RunWithOutput(stdout, io.Discard))
+		assert.NoError(t, err)
+		assert.Equal(t, "still running\n", stdout.String())
+	})
+
+	t.Run("copies raw output when a TTY is allocated", func(t *testing.T) {
+		statusCh, errCh := waitResponse(0)
+		c := runMock(strings.NewReader("raw tty output\r\n"), statusCh, errCh)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()))
+		stdout := &bytes.Buffer{}
+		err := d.Run(context.Background(), RunWithImage("busybox"), RunWithTTY(), RunWithOutput(stdout, io.Discard))
+		assert.NoError(t, err)
+		assert.Equal(t, "raw tty output\r\n", stdout.String())
+	})
+
+	t.Run("forwards stdin to the container", func(t *testing.T) {
+		output, outputWriter := io.Pipe()
+		statusCh := make(chan container.ContainerWaitOKBody, 1)
+		client, server := net.Pipe()
+
+		c := &mocks.ContainerAPIClient{}
+		c.On("ContainerCreate", mock.Anything, mock.MatchedBy(func(cfg *container.Config) bool {
+			return cfg.OpenStdin && cfg.AttachStdin && cfg.StdinOnce
+		}), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Once().
+			Return(container.ContainerCreateCreatedBody{ID: "abc123"}, nil)
+		c.On("ContainerInspect", mock.Anything, "abc123").
+			Once().
+			Return(types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{Name: "/ankor_test"}}, nil)
+		c.On("ContainerAttach", mock.Anything, "abc123", types.ContainerAttachOptions{Stream: true, Stdin: true, Stdout: true, Stderr: true}).
+			Once().
+			Return(types.HijackedResponse{Conn: client, Reader: bufio.NewReader(output)}, nil)
+		c.On("ContainerWait", mock.Anything, "abc123", container.WaitConditionNextExit).
+			Once().
+			Return((<-chan container.ContainerWaitOKBody)(statusCh), (<-chan error)(make(chan error)))
+		c.On("ContainerStart", mock.Anything, "abc123", mock.Anything).Once().Return(nil)
+
+		received := make([]byte, len("echo hello\n"))
+		go func() {
+			_, _ = io.ReadFull(server, received)
+			statusCh <- container.ContainerWaitOKBody{}
+			_ = outputWriter.Close()
+		}()
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()))
+		err := d.Run(context.Background(), RunWithImage("busybox"), RunWithStdin(strings.NewReader("echo hello\n")), RunWithOutput(io.Discard, io.Discard))
+		assert.NoError(t, err)
+		assert.Equal(t, "echo hello\n", string(received))
+		c.AssertExpectations(t)
+	})
+
+	t.Run("returns a ContainerExitError for a non-zero exit code", func(t *testing.T) {
+		statusCh, errCh := waitResponse(3)
+		c := runMock(multiplexed("line 1\nline 2\nline 3\n", "failure\n"), statusCh, errCh)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()))
+		err := d.Run(context.Background(), RunWithImage("busybox"), RunWithOutput(io.Discard, io.Discard), RunWithExitLogLines(2))
+		assert.Error(t, err)
+
+		var exitErr *ContainerExitError
+		assert.True(t, errors.As(err, &exitErr))
+		assert.Equal(t, int64(3), exitErr.StatusCode)
+		assert.Equal(t, "abc123", exitErr.ContainerID)
+		assert.Equal(t, "ankor_test", exitErr.ContainerName)
+		assert.Equal(t, []string{"line 3", "failure"}, exitErr.Logs)
+		assert.Equal(t, "container ankor_test exited with status 3", err.Error())
+	})
+
+	t.Run("returns wait errors reported by the daemon", func(t *testing.T) {
+		statusCh := make(chan container.ContainerWaitOKBody, 1)
+		statusCh <- container.ContainerWaitOKBody{Error: &container.ContainerWaitOKBodyError{Message: "wait failed"}}
+		c := runMock(multiplexed("", ""), statusCh, make(chan error))
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()))
+		err := d.Run(context.Background(), RunWithImage("busybox"), RunWithOutput(io.Discard, io.Discard))
+		assert.ErrorContains(t, err, "wait failed")
+	})
+
+	t.Run("returns attach errors", func(t *testing.T) {
+		c := &mocks.ContainerAPIClient{}
+		c.On("ContainerCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Once().
+			Return(container.ContainerCreateCreatedBody{ID: "abc123"}, nil)
+		c.On("ContainerInspect", mock.Anything, "abc123").
+			Once().
+			Return(types.ContainerJSON{}, errors.New("inspect failed"))
+		c.On("ContainerAttach", mock.Anything, "abc123", mock.Anything).
+			Once().
+			Return(types.HijackedResponse{}, errors.New("attach failed"))
+		c.On("ContainerRemove", mock.Anything, "abc123", types.ContainerRemoveOptions{Force: true, RemoveVolumes: true}).
+			Once().
+			Return(nil)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()))
+		err := d.Run(context.Background(), RunWithImage("busybox"))
+		assert.ErrorContains(t, err, "attach failed")
+		c.AssertExpectations(t)
+	})
+
+	t.Run("labels and auto removes containers by default", func(t *testing.T) {
+		statusCh, errCh := waitResponse(0)
+		c := runMock(multiplexed("", ""), statusCh, errCh)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()), WithCommand("ankor test"), WithVersion("1.2.3"))
+		err := d.Run(context.Background(), RunWithImage("busybox"), RunWithOutput(io.Discard, io.Discard))
+		assert.NoError(t, err)
+
+		cfg := c.Calls[0].Arguments.Get(1).(*container.Config)
+		hostCfg := c.Calls[0].Arguments.Get(2).(*container.HostConfig)
+		assert.True(t, hostCfg.AutoRemove)
+		assert.Equal(t, "true", cfg.Labels[LabelManaged])
+		assert.Equal(t, d.invocation, cfg.Labels[LabelInvocation])
+		assert.Equal(t, "ankor test", cfg.Labels[LabelCommand])
+		assert.Equal(t, "1.2.3", cfg.Labels[LabelVersion])
 	})
+
+	t.Run("keeps the container when auto remove is disabled", func(t *testing.T) {
+		statusCh, errCh := waitResponse(0)
+		c := runMock(multiplexed("", ""), statusCh, errCh)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()))
+		err := d.Run(context.Background(), RunWithImage("busybox"), RunWithAutoRemove(false), RunWithOutput(io.Discard, io.Discard))
+		assert.NoError(t, err)
+
+		hostCfg := c.Calls[0].Arguments.Get(2).(*container.HostConfig)
+		assert.False(t, hostCfg.AutoRemove)
+	})
+
+	// interruptedRun runs a container that keeps running until ctx is
+	// cancelled once it has written its first output.
+	interruptedRun := func(c *mocks.ContainerAPIClient, w *io.PipeWriter, opts ...RunOpt) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stdout := &signalWriter{written: make(chan struct{}, 1)}
+		go func() {
+			_, _ = stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte("still running\n"))
+			<-stdout.written
+			cancel()
+		}()
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()))
+		return d.Run(ctx, append(opts, RunWithImage("busybox"), RunWithOutput(stdout, io.Discard))...)
+	}
+
+	t.Run("stops and removes the container when the context is cancelled", func(t *testing.T) {
+		r, w := io.Pipe()
+		defer func() { _ = w.Close() }()
+		c := runMock(r, make(chan container.ContainerWaitOKBody), make(chan error))
+		timeout := DefaultStopTimeout
+		c.On("ContainerStop", mock.Anything, "abc123", &timeout).Once().Return(nil)
+		c.On("ContainerRemove", mock.Anything, "abc123", types.ContainerRemoveOptions{Force: true, RemoveVolumes: true}).
+			Once().
+			Return(nil)
+
+		err := interruptedRun(c, w)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorContains(t, err, "container ankor_test interrupted")
+		c.AssertExpectations(t)
+	})
+
+	t.Run("uses the stop timeout and keeps the container when interrupted", func(t *testing.T) {
+		r, w := io.Pipe()
+		defer func() { _ = w.Close() }()
+		c := runMock(r, make(chan container.ContainerWaitOKBody), make(chan error))
+		timeout := 2 * time.Second
+		c.On("ContainerStop", mock.Anything, "abc123", &timeout).Once().Return(nil)
+
+		err := interruptedRun(c, w, RunWithStopTimeout(timeout), RunWithAutoRemove(false))
+		assert.ErrorIs(t, err, context.Canceled)
+		c.AssertExpectations(t)
+		c.AssertNotCalled(t, "ContainerRemove", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestRunPullPolicy(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	viper.Reset()
+	pullResponse := func() io.ReadCloser {
+		return io.NopCloser(strings.NewReader(`{"status": "Pull complete", "id": "abc123"}`))
+	}
+	notFound := errdefs.NotFound(errors.New("No such image: busybox"))
+
+	t.Run("pulls missing images by default", func(t *testing.T) {
+		statusCh, errCh := waitResponse(0)
+		c := runMock(multiplexed("", ""), statusCh, errCh)
+		b := &mocks.ImageAPIClient{}
+		b.On("ImageInspectWithRaw", mock.Anything, "busybox").Once().Return(types.ImageInspect{}, nil, notFound)
+		b.On("ImagePull", mock.Anything, "busybox", mock.Anything).Once().Return(pullResponse(), nil)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(b), WithProgressMode(ProgressNone))
+		err := d.Run(context.Background(), RunWithImage("busybox"), RunWithOutput(io.Discard, io.Discard))
+		assert.NoError(t, err)
+		b.AssertExpectations(t)
+	})
+
+	t.Run("does not pull present images", func(t *testing.T) {
+		statusCh, errCh := waitResponse(0)
+		c := runMock(multiplexed("", ""), statusCh, errCh)
+		b := &mocks.ImageAPIClient{}
+		b.On("ImageInspectWithRaw", mock.Anything, "busybox").Once().Return(types.ImageInspect{ID: "sha256:abc"}, nil, nil)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(b))
+		err := d.Run(context.Background(), RunWithImage("busybox"), RunWithPullPolicy(PullIfNotPresent), RunWithOutput(io.Discard, io.Discard))
+		assert.NoError(t, err)
+		b.AssertNotCalled(t, "ImagePull", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("always pulls for the requested platform", func(t *testing.T) {
+		statusCh, errCh := waitResponse(0)
+		c := runMock(multiplexed("", ""), statusCh, errCh)
+		b := &mocks.ImageAPIClient{}
+		b.On("ImagePull", mock.Anything, "busybox", mock.MatchedBy(func(opts types.ImagePullOptions) bool {
+			return opts.Platform == "linux/arm64/v8"
+		})).Once().Return(pullResponse(), nil)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(b), WithProgressMode(ProgressNone))
+		err := d.Run(context.Background(), RunWithImage("busybox"), RunWithPullPolicy(PullAlways), RunWithPlatform("linux/arm64/v8"),
+			RunWithOutput(io.Discard, io.Discard))
+		assert.NoError(t, err)
+		b.AssertExpectations(t)
+		b.AssertNotCalled(t, "ImageInspectWithRaw", mock.Anything, mock.Anything)
+	})
+
+	t.Run("never pulls", func(t *testing.T) {
+		statusCh, errCh := waitResponse(0)
+		c := runMock(multiplexed("", ""), statusCh, errCh)
+		b := &mocks.ImageAPIClient{}
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(b))
+		err := d.Run(context.Background(), RunWithImage("busybox"), RunWithPullPolicy(PullNever), RunWithOutput(io.Discard, io.Discard))
+		assert.NoError(t, err)
+		b.AssertExpectations(t)
+	})
+
+	t.Run("does not create the container when the pull fails", func(t *testing.T) {
+		c := &mocks.ContainerAPIClient{}
+		b := &mocks.ImageAPIClient{}
+		b.On("ImageInspectWithRaw", mock.Anything, "busybox").Once().Return(types.ImageInspect{}, nil, notFound)
+		b.On("ImagePull", mock.Anything, "busybox", mock.Anything).
+			Once().
+			Return(io.NopCloser(strings.NewReader(`{"errorDetail": {"message": "pull access denied"}, "error": "pull access denied"}`)), nil)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(b), WithProgressMode(ProgressNone))
+		err := d.Run(context.Background(), RunWithImage("busybox"), RunWithPullPolicy(PullIfNotPresent))
+		assert.ErrorContains(t, err, "pull access denied")
+		c.AssertNotCalled(t, "ContainerCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("returns inspect errors", func(t *testing.T) {
+		b := &mocks.ImageAPIClient{}
+		b.On("ImageInspectWithRaw", mock.Anything, "busybox").Once().Return(types.ImageInspect{}, nil, errors.New("connection refused"))
+
+		d, _ := NewClient(WithContainerClient(&mocks.ContainerAPIClient{}), WithImageClient(b))
+		err := d.Run(context.Background(), RunWithImage("busybox"), RunWithPullPolicy(PullIfNotPresent))
+		assert.ErrorContains(t, err, "connection refused")
+	})
+}
+
+func TestGarbageCollect(t *testing.T) {
+	t.Run("prunes old ankor containers", func(t *testing.T) {
+		c := &mocks.ContainerAPIClient{}
+		c.On("ContainersPrune", mock.Anything, mock.MatchedBy(func(args filters.Args) bool {
+			return args.ExactMatch("label", LabelManaged+"=true") && args.ExactMatch("until", "24h0m0s")
+		})).
+			Once().
+			Return(types.ContainersPruneReport{ContainersDeleted: []string{"abc123"}}, nil)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()))
+		report, err := d.GarbageCollect(context.Background(), 24*time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"abc123"}, report.ContainersDeleted)
+		c.AssertExpectations(t)
+	})
+
+	t.Run("returns prune errors", func(t *testing.T) {
+		c := &mocks.ContainerAPIClient{}
+		c.On("ContainersPrune", mock.Anything, mock.Anything).
+			Once().
+			Return(types.ContainersPruneReport{}, errors.New("prune failed"))
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()))
+		_, err := d.GarbageCollect(context.Background(), time.Hour)
+		assert.Error(t, err)
+	})
+}
+
+func TestTailBuffer(t *testing.T) {
+	tail := newTailBuffer(3)
+	_, _ = tail.Write([]byte("one\ntwo\nthr"))
+	_, _ = tail.Write([]byte("ee\r\nfour\nfi"))
+	assert.Equal(t, []string{"three", "four", "fi"}, tail.Lines())
+
+	empty := newTailBuffer(0)
+	_, _ = empty.Write([]byte("ignored\n"))
+	assert.Empty(t, empty.Lines())
 }
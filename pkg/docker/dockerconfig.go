@@ -0,0 +1,115 @@
+package docker
+
+import (
+	"os"
+	"sort"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	clitypes "github.com/docker/cli/cli/config/types"
+	"github.com/docker/docker/api/types"
+	"github.com/go-errors/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// DockerConfig names the helper serving the credentials stored by
+// `docker login`, including those kept by docker-credential-* helpers.
+const DockerConfig = "docker"
+
+// WithDockerConfigDir sets the directory holding the docker CLI config.json.
+// Defaults to DOCKER_CONFIG or ~/.docker.
+func WithDockerConfigDir(dir string) ClientOpt {
+	return func(c *Client) error {
+		c.dockerConfigDir = dir
+		return nil
+	}
+}
+
+// dockerConfigHelper reads credentials from the docker CLI configuration,
+// invoking the configured credsStore and credHelpers binaries only when
+// their credentials are needed.
+type dockerConfigHelper struct {
+	file  *configfile.ConfigFile
+	auths map[string]clitypes.AuthConfig
+}
+
+func newDockerConfigHelper(c *Client, _ Registry) (AuthHelper, error) {
+	dir := c.dockerConfigDir
+	if dir == "" {
+		dir = os.Getenv("DOCKER_CONFIG")
+	}
+	file, err := config.Load(dir)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "error reading docker config", 0)
+	}
+	return &dockerConfigHelper{file: file}, nil
+}
+
+// Hosts lists every registry holding credentials, which requires querying
+// all the configured credential helpers.
+func (h *dockerConfigHelper) Hosts() []string {
+	if h.auths == nil {
+		auths, err := h.file.GetAllCredentials()
+		if err != nil {
+			log.Warn().Err(err).Msg("Ignoring docker CLI credentials")
+			auths = map[string]clitypes.AuthConfig{}
+		}
+		h.auths = auths
+	}
+
+	var hosts []string
+	for host, ac := range h.auths {
+		if hasCredentials(ac) {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func (h *dockerConfigHelper) AuthConfig(host string) (types.AuthConfig, error) {
+	ac, ok := h.auths[host]
+	if !ok {
+		if isDockerHub(host) {
+			host = DockerHubIndexHost
+		}
+		var err error
+		if ac, err = h.file.GetAuthConfig(host); err != nil {
+			return types.AuthConfig{}, errors.Wrap(err, 0)
+		}
+	}
+	return types.AuthConfig{
+		Username:      ac.Username,
+		Password:      ac.Password,
+		Auth:          ac.Auth,
+		Email:         ac.Email,
+		ServerAddress: ac.ServerAddress,
+		IdentityToken: ac.IdentityToken,
+		RegistryToken: ac.RegistryToken,
+	}, nil
+}
+
+func hasCredentials(ac clitypes.AuthConfig) bool {
+	return ac.Username != "" || ac.Password != "" || ac.Auth != "" || ac.IdentityToken != "" || ac.RegistryToken != ""
+}
+
+// dockerConfigAuths returns the credentials of the docker CLI, used as a
+// fallback for the registries no other helper serves. Unreadable
+// credentials are logged and ignored.
+func (c *Client) dockerConfigAuths() map[string]types.AuthConfig {
+	var auths = map[string]types.AuthConfig{}
+	helper, err := c.newAuthHelper(Registry{Helper: DockerConfig})
+	if err != nil {
+		log.Warn().Err(err).Msg("Ignoring docker CLI credentials")
+		return auths
+	}
+	for _, host := range helper.Hosts() {
+		ac, err := helper.AuthConfig(host)
+		if err != nil {
+			log.Warn().Err(err).Str("registry", host).Msg("Ignoring docker CLI credentials")
+			continue
+		}
+		auths[host] = ac
+	}
+	return auths
+}
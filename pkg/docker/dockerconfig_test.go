@@ -0,0 +1,83 @@
+package docker
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeDockerConfig(t *testing.T, content string) string {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(content), 0600))
+	return dir
+}
+
+func TestDockerConfigCredentials(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("hubuser:hubpass"))
+
+	t.Run("reads auths from config.json", func(t *testing.T) {
+		useConfig(t, `{}`)
+		dir := writeDockerConfig(t, `{"auths": {"https://index.docker.io/v1/": {"auth": "`+auth+`"}}}`)
+
+		d, _ := NewClient(WithDockerConfigDir(dir))
+		result, err := d.GetAuthConfig()
+		assert.NoError(t, err)
+
+		ac, ok := authConfigFor(result, DockerHubHost)
+		assert.True(t, ok)
+		assert.Equal(t, "hubuser", ac.Username)
+		assert.Equal(t, "hubpass", ac.Password)
+	})
+
+	t.Run("uses DOCKER_CONFIG", func(t *testing.T) {
+		useConfig(t, `{}`)
+		t.Setenv("DOCKER_CONFIG", writeDockerConfig(t, `{"auths": {"quay.io": {"auth": "`+auth+`"}}}`))
+
+		d, _ := NewClient()
+		result, err := d.GetAuthConfig()
+		assert.NoError(t, err)
+		assert.Equal(t, "hubuser", result["quay.io"].Username)
+	})
+
+	t.Run("configured helpers take precedence", func(t *testing.T) {
+		useConfig(t, `{"docker": {"registries": [{"host": "quay.io", "helper": "static", "token": "override"}]}}`)
+		dir := writeDockerConfig(t, `{"auths": {"quay.io": {"auth": "`+auth+`"}}}`)
+
+		d, _ := NewClient(WithDockerConfigDir(dir))
+		result, err := d.GetAuthConfig()
+		assert.NoError(t, err)
+		assert.Equal(t, "override", result["quay.io"].Password)
+	})
+
+	t.Run("invokes credential helpers", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("credential helper script requires a unix shell")
+		}
+		useConfig(t, `{}`)
+		bin := t.TempDir()
+		script := "#!/bin/sh\nread host\necho '{\"ServerURL\":\"'$host'\",\"Username\":\"helper\",\"Secret\":\"helper-secret\"}'\n"
+		assert.NoError(t, os.WriteFile(filepath.Join(bin, "docker-credential-ankortest"), []byte(script), 0700))
+		t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+		dir := writeDockerConfig(t, `{"credHelpers": {"registry.example.com": "ankortest"}}`)
+
+		d, _ := NewClient(WithDockerConfigDir(dir))
+		result, err := d.GetAuthConfig()
+		assert.NoError(t, err)
+		assert.Equal(t, "helper", result["registry.example.com"].Username)
+		assert.Equal(t, "helper-secret", result["registry.example.com"].Password)
+	})
+
+	t.Run("ignores unreadable credentials", func(t *testing.T) {
+		useConfig(t, `{}`)
+		dir := writeDockerConfig(t, `{"credsStore": "ankor-missing-store"}`)
+
+		d, _ := NewClient(WithDockerConfigDir(dir))
+		result, err := d.GetAuthConfig()
+		assert.NoError(t, err)
+		assert.Empty(t, result)
+	})
+}
@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// DefaultExitLogLines is the number of trailing output lines kept on a
+// ContainerExitError unless overridden with RunWithExitLogLines.
+const DefaultExitLogLines = 20
+
+// ContainerExitError is returned by Run when a container exits with a
+// non-zero status code.
+type ContainerExitError struct {
+	StatusCode    int64
+	ContainerID   string
+	ContainerName string
+	// Logs holds the last lines the container wrote to stdout and stderr.
+	Logs []string
+}
+
+func (e *ContainerExitError) Error() string {
+	name := e.ContainerName
+	if name == "" {
+		name = e.ContainerID
+	}
+	return fmt.Sprintf("container %s exited with status %d", name, e.StatusCode)
+}
+
+// ExecExitError is returned by Exec when a command exits with a non-zero
+// exit code.
+type ExecExitError struct {
+	ExitCode    int
+	ContainerID string
+	ExecID      string
+	Cmd         []string
+	// Logs holds the last lines the command wrote to stdout and stderr.
+	Logs []string
+}
+
+func (e *ExecExitError) Error() string {
+	return fmt.Sprintf("'%s' exited with code %d in container %s", strings.Join(e.Cmd, " "), e.ExitCode, e.ContainerID)
+}
+
+// tailBuffer keeps the last n lines written to it.
+type tailBuffer struct {
+	n       int
+	lines   []string
+	partial bytes.Buffer
+}
+
+func newTailBuffer(n int) *tailBuffer {
+	return &tailBuffer{n: n}
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	if t.n <= 0 {
+		return len(p), nil
+	}
+	t.partial.Write(p)
+	for {
+		line, err := t.partial.ReadString('\n')
+		if err != nil {
+			// put back the incomplete line until the rest of it arrives
+			t.partial.Reset()
+			t.partial.WriteString(line)
+			break
+		}
+		t.push(strings.TrimRight(line, "\r\n"))
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) push(line string) {
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.n {
+		t.lines = t.lines[len(t.lines)-t.n:]
+	}
+}
+
+// Lines returns the retained lines including any unterminated last line.
+func (t *tailBuffer) Lines() []string {
+	lines := append([]string{}, t.lines...)
+	if t.partial.Len() > 0 {
+		lines = append(lines, t.partial.String())
+		if len(lines) > t.n {
+			lines = lines[len(lines)-t.n:]
+		}
+	}
+	return lines
+}
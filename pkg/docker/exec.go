@@ -0,0 +1,85 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/go-errors/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// Exec runs cmd in a running container, e.g. to seed a database, streaming
+// its output until it exits. A non-zero exit code is returned as an
+// ExecExitError. The daemon cannot stop an exec, so cancelling ctx only
+// stops streaming its output.
+func (c *Client) Exec(ctx context.Context, containerID string, cmd []string, opts ...ExecOpt) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+
+	execConfig, err := newExecConfig(cmd, opts...)
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("container", containerID).
+		Str("cmd", strings.Join(cmd, " ")).
+		Msg("Executing command")
+
+	resp, err := c.containers.ContainerExecCreate(ctx, containerID, execConfig.Config)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	tty := execConfig.Config.Tty
+	attach, err := c.containers.ContainerExecAttach(ctx, resp.ID, types.ExecStartCheck{Tty: tty})
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer attach.Close()
+
+	output, err := execConfig.stream(attach, tty)
+	if err != nil {
+		return err
+	}
+	defer output.restore()
+	if tty {
+		monitorCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		c.monitorTTYSize(monitorCtx, c.containers.ContainerExecResize, resp.ID, execConfig.stdout())
+	}
+
+	// the attached stream is closed by the daemon once the command exits,
+	// but it does not follow ctx once hijacked
+	select {
+	case <-ctx.Done():
+		return errors.WrapPrefix(ctx.Err(), fmt.Sprintf("exec in container %s interrupted", containerID), 0)
+	case err := <-output.done:
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+	}
+
+	inspect, err := c.containers.ContainerExecInspect(ctx, resp.ID)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	if inspect.ExitCode != 0 {
+		return errors.Wrap(&ExecExitError{
+			ExitCode:    inspect.ExitCode,
+			ContainerID: containerID,
+			ExecID:      resp.ID,
+			Cmd:         cmd,
+			Logs:        output.tail.Lines(),
+		}, 0)
+	}
+	return nil
+}
+
+// Exec runs cmd in the container, see Client.Exec.
+func (ct *Container) Exec(ctx context.Context, cmd []string, opts ...ExecOpt) error {
+	return ct.client.Exec(ctx, ct.ID, cmd, opts...)
+}
@@ -0,0 +1,130 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/docker/docker/api/types"
+	"github.com/go-errors/errors"
+)
+
+type ExecOpt func(*ExecConfig) error
+
+type ExecConfig struct {
+	Config types.ExecConfig
+	Streams
+	declarations
+}
+
+// newExecConfig applies the supplied options to an exec of cmd.
+func newExecConfig(cmd []string, opts ...ExecOpt) (*ExecConfig, error) {
+	if len(cmd) == 0 {
+		return nil, errors.New(fmt.Errorf("'Command' %w", ErrMissingOption))
+	}
+	cfg := &ExecConfig{
+		Config: types.ExecConfig{
+			Cmd:          cmd,
+			AttachStdout: true,
+			AttachStderr: true,
+		},
+	}
+	for _, o := range opts {
+		if err := o(cfg); err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+	}
+	return cfg, nil
+}
+
+// ExecWithEnv adds the supplied variables to the environment of the command.
+func ExecWithEnv(env map[string]string) ExecOpt {
+	return func(cfg *ExecConfig) error {
+		keys := make([]string, 0, len(env))
+		for k := range env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			cfg.Config.Env = append(cfg.Config.Env, fmt.Sprintf("%s=%s", k, env[k]))
+		}
+		return nil
+	}
+}
+
+// ExecWithUser sets the user the command runs as, e.g. "postgres" or
+// "1000:1000". Defaults to the user of the container.
+func ExecWithUser(user string) ExecOpt {
+	return func(cfg *ExecConfig) error {
+		if err := cfg.declare("User"); err != nil {
+			return err
+		}
+		cfg.Config.User = user
+		return nil
+	}
+}
+
+// ExecWithWorkingDir sets the directory the command runs in. Defaults to the
+// working directory of the container.
+func ExecWithWorkingDir(dir string) ExecOpt {
+	return func(cfg *ExecConfig) error {
+		if err := cfg.declare("WorkingDir"); err != nil {
+			return err
+		}
+		cfg.Config.WorkingDir = dir
+		return nil
+	}
+}
+
+// ExecWithTTY allocates a pseudo-TTY for the command, merging its stdout and
+// stderr.
+func ExecWithTTY() ExecOpt {
+	return func(cfg *ExecConfig) error {
+		if err := cfg.declare("TTY"); err != nil {
+			return err
+		}
+		cfg.Config.Tty = true
+		return nil
+	}
+}
+
+// ExecWithOutput sets the writers the command's stdout and stderr are
+// streamed to. Defaults to os.Stdout and os.Stderr.
+func ExecWithOutput(stdout, stderr io.Writer) ExecOpt {
+	return func(cfg *ExecConfig) error {
+		if err := cfg.declare("Output"); err != nil {
+			return err
+		}
+		cfg.Stdout = stdout
+		cfg.Stderr = stderr
+		return nil
+	}
+}
+
+// ExecWithStdin attaches in to the command's stdin, e.g. a SQL dump piped
+// into psql.
+func ExecWithStdin(in io.Reader) ExecOpt {
+	return func(cfg *ExecConfig) error {
+		if err := cfg.declare("Stdin"); err != nil {
+			return err
+		}
+		cfg.Stdin = in
+		cfg.Config.AttachStdin = true
+		return nil
+	}
+}
+
+// ExecWithExitLogLines sets how many trailing output lines are kept on the
+// ExecExitError returned when the command fails.
+func ExecWithExitLogLines(n int) ExecOpt {
+	return func(cfg *ExecConfig) error {
+		if err := cfg.declare("ExitLogLines"); err != nil {
+			return err
+		}
+		if n < 0 {
+			return errors.New(fmt.Errorf("'ExitLogLines' must not be negative, got %d", n))
+		}
+		cfg.ExitLogLines = &n
+		return nil
+	}
+}
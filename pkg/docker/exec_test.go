@@ -0,0 +1,151 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ankorstore/ankorstore-cli-modules/pkg/docker/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/go-errors/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func execMock(output io.Reader, exitCode int) *mocks.ContainerAPIClient {
+	c := &mocks.ContainerAPIClient{}
+	c.On("ContainerExecCreate", mock.Anything, "abc123", mock.Anything).
+		Once().
+		Return(types.IDResponse{ID: "exec1"}, nil)
+	c.On("ContainerExecAttach", mock.Anything, "exec1", mock.Anything).
+		Once().
+		Return(attachResponse(output), nil)
+	c.On("ContainerExecInspect", mock.Anything, "exec1").
+		Once().
+		Return(types.ContainerExecInspect{ExecID: "exec1", ExitCode: exitCode}, nil)
+	return c
+}
+
+func TestExec(t *testing.T) {
+	t.Run("streams the output of the command", func(t *testing.T) {
+		c := execMock(multiplexed("seeded 3 rows\n", "warning\n"), 0)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(&mocks.ImageAPIClient{}))
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		err := d.Exec(context.Background(), "abc123", []string{"psql", "-f", "seed.sql"},
+			ExecWithEnv(map[string]string{"PGUSER": "app", "PGDATABASE": "app"}),
+			ExecWithUser("postgres"),
+			ExecWithWorkingDir("/seeds"),
+			ExecWithOutput(stdout, stderr))
+		assert.NoError(t, err)
+		assert.Equal(t, "seeded 3 rows\n", stdout.String())
+		assert.Equal(t, "warning\n", stderr.String())
+
+		c.AssertCalled(t, "ContainerExecCreate", mock.Anything, "abc123", types.ExecConfig{
+			User:         "postgres",
+			AttachStdout: true,
+			AttachStderr: true,
+			Env:          []string{"PGDATABASE=app", "PGUSER=app"},
+			WorkingDir:   "/seeds",
+			Cmd:          []string{"psql", "-f", "seed.sql"},
+		})
+		c.AssertExpectations(t)
+	})
+
+	t.Run("copies raw output from a TTY", func(t *testing.T) {
+		c := execMock(strings.NewReader("raw tty output\r\n"), 0)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(&mocks.ImageAPIClient{}))
+		stdout := &bytes.Buffer{}
+		err := d.Exec(context.Background(), "abc123", []string{"sh"}, ExecWithTTY(), ExecWithOutput(stdout, io.Discard))
+		assert.NoError(t, err)
+		assert.Equal(t, "raw tty output\r\n", stdout.String())
+		c.AssertCalled(t, "ContainerExecAttach", mock.Anything, "exec1", types.ExecStartCheck{Tty: true})
+	})
+
+	t.Run("forwards stdin to the command", func(t *testing.T) {
+		output, outputWriter := io.Pipe()
+		client, server := net.Pipe()
+
+		c := &mocks.ContainerAPIClient{}
+		c.On("ContainerExecCreate", mock.Anything, "abc123", mock.MatchedBy(func(cfg types.ExecConfig) bool {
+			return cfg.AttachStdin
+		})).
+			Once().
+			Return(types.IDResponse{ID: "exec1"}, nil)
+		c.On("ContainerExecAttach", mock.Anything, "exec1", mock.Anything).
+			Once().
+			Return(types.HijackedResponse{Conn: client, Reader: bufio.NewReader(output)}, nil)
+		c.On("ContainerExecInspect", mock.Anything, "exec1").Once().Return(types.ContainerExecInspect{}, nil)
+
+		received := make([]byte, len("select 1;\n"))
+		go func() {
+			_, _ = io.ReadFull(server, received)
+			_ = outputWriter.Close()
+		}()
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(&mocks.ImageAPIClient{}))
+		err := d.Exec(context.Background(), "abc123", []string{"psql"}, ExecWithStdin(strings.NewReader("select 1;\n")), ExecWithOutput(io.Discard, io.Discard))
+		assert.NoError(t, err)
+		assert.Equal(t, "select 1;\n", string(received))
+	})
+
+	t.Run("returns an ExecExitError for a non-zero exit code", func(t *testing.T) {
+		c := execMock(multiplexed("migrating\n", "relation exists\n"), 2)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(&mocks.ImageAPIClient{}))
+		err := d.Exec(context.Background(), "abc123", []string{"migrate", "up"}, ExecWithOutput(io.Discard, io.Discard), ExecWithExitLogLines(1))
+
+		var exitErr *ExecExitError
+		assert.True(t, errors.As(err, &exitErr))
+		assert.Equal(t, 2, exitErr.ExitCode)
+		assert.Equal(t, "exec1", exitErr.ExecID)
+		assert.Equal(t, []string{"relation exists"}, exitErr.Logs)
+		assert.Equal(t, "'migrate up' exited with code 2 in container abc123", err.Error())
+	})
+
+	t.Run("stops streaming when the context is cancelled", func(t *testing.T) {
+		r, w := io.Pipe()
+		defer func() { _ = w.Close() }()
+		c := &mocks.ContainerAPIClient{}
+		c.On("ContainerExecCreate", mock.Anything, "abc123", mock.Anything).
+			Once().
+			Return(types.IDResponse{ID: "exec1"}, nil)
+		c.On("ContainerExecAttach", mock.Anything, "exec1", mock.Anything).
+			Once().
+			Return(attachResponse(r), nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(&mocks.ImageAPIClient{}))
+		err := d.Exec(ctx, "abc123", []string{"tail", "-f", "/dev/null"}, ExecWithOutput(io.Discard, io.Discard))
+		assert.ErrorIs(t, err, context.Canceled)
+		c.AssertNotCalled(t, "ContainerExecInspect", mock.Anything, mock.Anything)
+	})
+
+	t.Run("returns create errors", func(t *testing.T) {
+		c := &mocks.ContainerAPIClient{}
+		c.On("ContainerExecCreate", mock.Anything, "abc123", mock.Anything).
+			Once().
+			Return(types.IDResponse{}, errors.New("container abc123 is not running"))
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(&mocks.ImageAPIClient{}))
+		err := d.Exec(context.Background(), "abc123", []string{"ls"})
+		assert.ErrorContains(t, err, "is not running")
+	})
+
+	t.Run("requires a command", func(t *testing.T) {
+		d, _ := NewClient(WithContainerClient(&mocks.ContainerAPIClient{}), WithImageClient(&mocks.ImageAPIClient{}))
+		err := d.Exec(context.Background(), "abc123", nil)
+		assert.ErrorIs(t, err, ErrMissingOption)
+	})
+
+	t.Run("cannot redeclare options", func(t *testing.T) {
+		_, err := newExecConfig([]string{"ls"}, ExecWithUser("root"), ExecWithUser("app"))
+		assert.ErrorIs(t, err, ErrCannotRedeclare)
+	})
+}
@@ -0,0 +1,38 @@
+package docker
+
+const (
+	LabelPrefix     = "com.ankorstore.ankor"
+	LabelManaged    = LabelPrefix + ".managed"
+	LabelInvocation = LabelPrefix + ".invocation"
+	LabelCommand    = LabelPrefix + ".command"
+	LabelVersion    = LabelPrefix + ".version"
+	LabelStack      = LabelPrefix + ".stack"
+	LabelService    = LabelPrefix + ".service"
+	LabelCache      = LabelPrefix + ".cache"
+)
+
+// labels returns the labels identifying objects created by this client.
+func (c *Client) labels() map[string]string {
+	labels := map[string]string{
+		LabelManaged:    "true",
+		LabelInvocation: c.invocation,
+		LabelCommand:    c.command,
+	}
+	if c.version != "" {
+		labels[LabelVersion] = c.version
+	}
+	return labels
+}
+
+// withLabels merges the ankor labels into labels, ankor labels taking
+// precedence over any supplied value.
+func (c *Client) withLabels(labels map[string]string) map[string]string {
+	merged := make(map[string]string, len(labels)+4)
+	for k, v := range labels {
+		merged[k] = v
+	}
+	for k, v := range c.labels() {
+		merged[k] = v
+	}
+	return merged
+}
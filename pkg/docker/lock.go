@@ -0,0 +1,241 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/go-errors/errors"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/opencontainers/go-digest"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultLockFile is the lock file read from the working directory unless
+	// WithLockFile overrides it.
+	DefaultLockFile = "ankor.lock"
+	lockVersion     = 1
+)
+
+var (
+	ErrNotLocked              = errors.New("is not pinned in the lock file")
+	ErrUnsupportedLockVersion = errors.New("is not a supported lock file version")
+	ErrDigestReference        = errors.New("already references a digest and cannot be locked")
+)
+
+// Lock pins image tags to the digests they resolved to, so runs use the
+// same images until the lock is updated.
+type Lock struct {
+	Version int `json:"version"`
+	// Images maps familiar tagged references, e.g. postgres:14, to digests.
+	Images map[string]string `json:"images"`
+	path   string
+}
+
+// LoadLock reads the lock file at path, returning an empty lock when it does
+// not exist yet.
+func LoadLock(path string) (*Lock, error) {
+	lock := &Lock{Version: lockVersion, Images: map[string]string{}, path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, errors.WrapPrefix(err, path, 0)
+	}
+	if lock.Version != lockVersion {
+		return nil, errors.WrapPrefix(errors.New(fmt.Errorf("'%d' %w", lock.Version, ErrUnsupportedLockVersion)), path, 0)
+	}
+	if lock.Images == nil {
+		lock.Images = map[string]string{}
+	}
+	return lock, nil
+}
+
+// Save writes the lock back to the file it was loaded from.
+func (l *Lock) Save() error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	if err := os.WriteFile(l.path, append(data, '\n'), 0644); err != nil {
+		return errors.Wrap(err, 0)
+	}
+	return nil
+}
+
+// Pin returns image pinned to its locked digest, e.g. postgres@sha256:...
+// for postgres:14. References that already carry a digest are returned
+// unchanged and others fail with ErrNotLocked.
+func (l *Lock) Pin(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", errors.Wrap(err, 0)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return image, nil
+	}
+	key := lockKey(named)
+	dgst, ok := l.Images[key]
+	if !ok {
+		return "", errors.New(fmt.Errorf("'%s' %w", key, ErrNotLocked))
+	}
+	parsed, err := digest.Parse(dgst)
+	if err != nil {
+		return "", errors.WrapPrefix(err, fmt.Sprintf("%s: '%s'", l.path, key), 0)
+	}
+	pinned, err := reference.WithDigest(reference.TrimNamed(named), parsed)
+	if err != nil {
+		return "", errors.Wrap(err, 0)
+	}
+	return reference.FamiliarString(pinned), nil
+}
+
+// lockKey is the key an image is locked under, defaulting to the latest tag.
+func lockKey(named reference.Named) string {
+	return reference.FamiliarString(reference.TagNameOnly(named))
+}
+
+// WithLockFile sets the lock file pinning the images of runs and pulls, an
+// empty path disabling pinning. Defaults to DefaultLockFile.
+func WithLockFile(path string) ClientOpt {
+	return func(c *Client) error {
+		c.lockFile = path
+		c.lock = nil
+		return nil
+	}
+}
+
+// loadLock returns the lock file of the client, read once, or nil when
+// pinning is disabled.
+func (c *Client) loadLock() (*Lock, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lockFile == "" {
+		return nil, nil
+	}
+	if c.lock == nil {
+		lock, err := LoadLock(c.lockFile)
+		if err != nil {
+			return nil, err
+		}
+		c.lock = lock
+	}
+	return c.lock, nil
+}
+
+// pinImage returns image pinned to its locked digest, or image itself when
+// it is not locked.
+func (c *Client) pinImage(image string) (string, error) {
+	lock, err := c.loadLock()
+	if err != nil || lock == nil {
+		return image, err
+	}
+	c.mu.Lock()
+	pinned, err := lock.Pin(image)
+	c.mu.Unlock()
+	if errors.Is(err, ErrNotLocked) {
+		return image, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if pinned != image {
+		log.Debug().Msgf("Using %s pinned by %s", pinned, lock.path)
+	}
+	return pinned, nil
+}
+
+// ResolveDigest asks the registry of image which digest its tag currently
+// points to, using the credentials configured for that registry.
+func (c *Client) ResolveDigest(ctx context.Context, image string) (string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", errors.Wrap(err, 0)
+	}
+	ac, err := c.authConfigForRef(image)
+	if err != nil {
+		return "", err
+	}
+	opts := []remote.Option{remote.WithContext(ctx), remote.WithAuth(registryAuthenticator(ac))}
+
+	desc, err := remote.Head(ref, opts...)
+	if err != nil {
+		// not every registry answers HEAD requests for manifests
+		log.Debug().Err(err).Msgf("Could not HEAD %s, getting its manifest", image)
+		full, err := remote.Get(ref, opts...)
+		if err != nil {
+			return "", errors.WrapPrefix(err, "error resolving "+image, 0)
+		}
+		return full.Digest.String(), nil
+	}
+	return desc.Digest.String(), nil
+}
+
+func registryAuthenticator(ac types.AuthConfig) authn.Authenticator {
+	if ac == (types.AuthConfig{}) {
+		return authn.Anonymous
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      ac.Username,
+		Password:      ac.Password,
+		Auth:          ac.Auth,
+		IdentityToken: ac.IdentityToken,
+		RegistryToken: ac.RegistryToken,
+	})
+}
+
+// UpdateLock resolves the supplied images to their current digests and
+// records them in the lock file of the client. Without images every image
+// already in the lock is refreshed.
+func (c *Client) UpdateLock(ctx context.Context, images ...string) error {
+	lock, err := c.loadLock()
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		return errors.New("no lock file is configured")
+	}
+	if len(images) == 0 {
+		for image := range lock.Images {
+			images = append(images, image)
+		}
+		sort.Strings(images)
+	}
+
+	resolved := make(map[string]string, len(images))
+	for _, image := range images {
+		named, err := reference.ParseNormalizedNamed(image)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+		if _, ok := named.(reference.Digested); ok {
+			return errors.New(fmt.Errorf("'%s' %w", image, ErrDigestReference))
+		}
+		key := lockKey(named)
+		if resolved[key], err = c.ResolveDigest(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, dgst := range resolved {
+		if lock.Images[key] != dgst {
+			log.Info().Str("image", key).Str("digest", dgst).Msg("Locking image")
+		}
+		lock.Images[key] = dgst
+	}
+	return lock.Save()
+}
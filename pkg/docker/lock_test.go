@@ -0,0 +1,188 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ankorstore/ankorstore-cli-modules/pkg/docker/mocks"
+	"github.com/docker/docker/api/types/container"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const (
+	postgresDigest = "sha256:3b5d4b3d8c5d8e1c7b1c7a1b46b3b4a4a1f4a3c5d6e7f8091a2b3c4d5e6f7081"
+	busyboxDigest  = "sha256:7a9f3c1e0b2d4f6a8c0e2f4a6b8d0f2a4c6e8a0c2e4a6c8e0a2c4e6a8c0e2f4a"
+)
+
+func writeLock(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), DefaultLockFile)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadLock(t *testing.T) {
+	t.Run("returns an empty lock for a missing file", func(t *testing.T) {
+		lock, err := LoadLock(filepath.Join(t.TempDir(), DefaultLockFile))
+		assert.NoError(t, err)
+		assert.Empty(t, lock.Images)
+	})
+
+	t.Run("saves and loads images", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), DefaultLockFile)
+		lock, _ := LoadLock(path)
+		lock.Images["postgres:14"] = postgresDigest
+		assert.NoError(t, lock.Save())
+
+		loaded, err := LoadLock(path)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"postgres:14": postgresDigest}, loaded.Images)
+	})
+
+	t.Run("rejects unsupported versions", func(t *testing.T) {
+		_, err := LoadLock(writeLock(t, `{"version": 2, "images": {}}`))
+		assert.ErrorIs(t, err, ErrUnsupportedLockVersion)
+		assert.ErrorContains(t, err, "'2' is not a supported lock file version")
+	})
+
+	t.Run("with malformed json", func(t *testing.T) {
+		_, err := LoadLock(writeLock(t, `{"version": 1, "images": `))
+		assert.Error(t, err)
+	})
+}
+
+func TestLockPin(t *testing.T) {
+	lock := &Lock{Images: map[string]string{
+		"postgres:14":    postgresDigest,
+		"busybox:latest": busyboxDigest,
+		"broken:1":       "not a digest",
+	}}
+
+	for image, expected := range map[string]string{
+		"postgres:14":                   "postgres@" + postgresDigest,
+		"docker.io/library/postgres:14": "postgres@" + postgresDigest,
+		"busybox":                       "busybox@" + busyboxDigest,
+		"postgres@" + busyboxDigest:     "postgres@" + busyboxDigest,
+	} {
+		pinned, err := lock.Pin(image)
+		assert.NoError(t, err, image)
+		assert.Equal(t, expected, pinned, image)
+	}
+
+	_, err := lock.Pin("postgres:15")
+	assert.ErrorIs(t, err, ErrNotLocked)
+
+	_, err = lock.Pin("broken:1")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNotLocked)
+}
+
+func TestRunWithLockFile(t *testing.T) {
+	lockFile := writeLock(t, `{"version": 1, "images": {"busybox:latest": "`+busyboxDigest+`"}}`)
+
+	t.Run("runs the pinned image", func(t *testing.T) {
+		statusCh, errCh := waitResponse(0)
+		c := runMock(multiplexed("", ""), statusCh, errCh)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()), WithLockFile(lockFile))
+		err := d.Run(context.Background(), RunWithImage("busybox"), RunWithOutput(io.Discard, io.Discard))
+		assert.NoError(t, err)
+
+		cfg := c.Calls[0].Arguments.Get(1).(*container.Config)
+		assert.Equal(t, "busybox@"+busyboxDigest, cfg.Image)
+	})
+
+	t.Run("runs unlocked images as is", func(t *testing.T) {
+		statusCh, errCh := waitResponse(0)
+		c := runMock(multiplexed("", ""), statusCh, errCh)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()), WithLockFile(lockFile))
+		err := d.Run(context.Background(), RunWithImage("alpine:3.16"), RunWithOutput(io.Discard, io.Discard))
+		assert.NoError(t, err)
+
+		cfg := c.Calls[0].Arguments.Get(1).(*container.Config)
+		assert.Equal(t, "alpine:3.16", cfg.Image)
+	})
+
+	t.Run("pulls the pinned image", func(t *testing.T) {
+		t.Setenv("DOCKER_CONFIG", t.TempDir())
+		viper.Reset()
+		b := &mocks.ImageAPIClient{}
+		b.On("ImagePull", mock.Anything, "busybox@"+busyboxDigest, mock.Anything).
+			Once().
+			Return(io.NopCloser(strings.NewReader(`{"status": "pulled"}`)), nil)
+
+		d, _ := NewClient(WithImageClient(b), WithContainerClient(&mocks.ContainerAPIClient{}), WithLockFile(lockFile))
+		assert.NoError(t, d.PullImage(context.Background(), "busybox"))
+		b.AssertExpectations(t)
+	})
+}
+
+func TestUpdateLock(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	viper.Reset()
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	push := func(t *testing.T, image string) v1.Hash {
+		img, err := random.Image(64, 1)
+		assert.NoError(t, err)
+		ref, err := name.ParseReference(image)
+		assert.NoError(t, err)
+		assert.NoError(t, remote.Write(ref, img))
+		dgst, err := img.Digest()
+		assert.NoError(t, err)
+		return dgst
+	}
+	tool := host + "/ankor/tool:1.0"
+	lockFile := filepath.Join(t.TempDir(), DefaultLockFile)
+	d, _ := NewClient(WithLockFile(lockFile))
+
+	t.Run("locks the supplied images", func(t *testing.T) {
+		dgst := push(t, tool)
+		assert.NoError(t, d.UpdateLock(context.Background(), tool))
+
+		lock, err := LoadLock(lockFile)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{tool: dgst.String()}, lock.Images)
+		pinned, err := d.pinImage(tool)
+		assert.NoError(t, err)
+		assert.Equal(t, host+"/ankor/tool@"+dgst.String(), pinned)
+	})
+
+	t.Run("refreshes every locked image", func(t *testing.T) {
+		dgst := push(t, tool)
+		assert.NoError(t, d.UpdateLock(context.Background()))
+
+		lock, err := LoadLock(lockFile)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{tool: dgst.String()}, lock.Images)
+	})
+
+	t.Run("fails for unknown images", func(t *testing.T) {
+		err := d.UpdateLock(context.Background(), host+"/ankor/missing:1.0")
+		assert.ErrorContains(t, err, "error resolving")
+	})
+
+	t.Run("rejects digest references", func(t *testing.T) {
+		err := d.UpdateLock(context.Background(), "busybox@"+busyboxDigest)
+		assert.ErrorIs(t, err, ErrDigestReference)
+	})
+
+	t.Run("requires a lock file", func(t *testing.T) {
+		d, _ := NewClient(WithLockFile(""))
+		assert.Error(t, d.UpdateLock(context.Background(), tool))
+	})
+}
@@ -0,0 +1,39 @@
+// Code generated by mockery v2.9.4. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	net "net"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// HijackDialer is an autogenerated mock type for the HijackDialer type
+type HijackDialer struct {
+	mock.Mock
+}
+
+// DialHijack provides a mock function with given fields: ctx, url, proto, meta
+func (_m *HijackDialer) DialHijack(ctx context.Context, url string, proto string, meta map[string][]string) (net.Conn, error) {
+	ret := _m.Called(ctx, url, proto, meta)
+
+	var r0 net.Conn
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, map[string][]string) net.Conn); ok {
+		r0 = rf(ctx, url, proto, meta)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(net.Conn)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, map[string][]string) error); ok {
+		r1 = rf(ctx, url, proto, meta)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -0,0 +1,126 @@
+// Code generated by mockery v2.9.4. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	types "github.com/docker/docker/api/types"
+	events "github.com/docker/docker/api/types/events"
+	registry "github.com/docker/docker/api/types/registry"
+	mock "github.com/stretchr/testify/mock"
+)
+
+// SystemAPIClient is an autogenerated mock type for the SystemAPIClient type
+type SystemAPIClient struct {
+	mock.Mock
+}
+
+// DiskUsage provides a mock function with given fields: ctx
+func (_m *SystemAPIClient) DiskUsage(ctx context.Context) (types.DiskUsage, error) {
+	ret := _m.Called(ctx)
+
+	var r0 types.DiskUsage
+	if rf, ok := ret.Get(0).(func(context.Context) types.DiskUsage); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(types.DiskUsage)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Events provides a mock function with given fields: ctx, options
+func (_m *SystemAPIClient) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	ret := _m.Called(ctx, options)
+
+	var r0 <-chan events.Message
+	if rf, ok := ret.Get(0).(func(context.Context, types.EventsOptions) <-chan events.Message); ok {
+		r0 = rf(ctx, options)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan events.Message)
+		}
+	}
+
+	var r1 <-chan error
+	if rf, ok := ret.Get(1).(func(context.Context, types.EventsOptions) <-chan error); ok {
+		r1 = rf(ctx, options)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(<-chan error)
+		}
+	}
+
+	return r0, r1
+}
+
+// Info provides a mock function with given fields: ctx
+func (_m *SystemAPIClient) Info(ctx context.Context) (types.Info, error) {
+	ret := _m.Called(ctx)
+
+	var r0 types.Info
+	if rf, ok := ret.Get(0).(func(context.Context) types.Info); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(types.Info)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Ping provides a mock function with given fields: ctx
+func (_m *SystemAPIClient) Ping(ctx context.Context) (types.Ping, error) {
+	ret := _m.Called(ctx)
+
+	var r0 types.Ping
+	if rf, ok := ret.Get(0).(func(context.Context) types.Ping); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(types.Ping)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RegistryLogin provides a mock function with given fields: ctx, auth
+func (_m *SystemAPIClient) RegistryLogin(ctx context.Context, auth types.AuthConfig) (registry.AuthenticateOKBody, error) {
+	ret := _m.Called(ctx, auth)
+
+	var r0 registry.AuthenticateOKBody
+	if rf, ok := ret.Get(0).(func(context.Context, types.AuthConfig) registry.AuthenticateOKBody); ok {
+		r0 = rf(ctx, auth)
+	} else {
+		r0 = ret.Get(0).(registry.AuthenticateOKBody)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, types.AuthConfig) error); ok {
+		r1 = rf(ctx, auth)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
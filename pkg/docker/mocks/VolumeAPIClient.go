@@ -0,0 +1,145 @@
+// Code generated by mockery v2.9.4. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	types "github.com/docker/docker/api/types"
+	filters "github.com/docker/docker/api/types/filters"
+	volume "github.com/docker/docker/api/types/volume"
+	mock "github.com/stretchr/testify/mock"
+)
+
+// VolumeAPIClient is an autogenerated mock type for the VolumeAPIClient type
+type VolumeAPIClient struct {
+	mock.Mock
+}
+
+// VolumeCreate provides a mock function with given fields: ctx, options
+func (_m *VolumeAPIClient) VolumeCreate(ctx context.Context, options volume.VolumeCreateBody) (types.Volume, error) {
+	ret := _m.Called(ctx, options)
+
+	var r0 types.Volume
+	if rf, ok := ret.Get(0).(func(context.Context, volume.VolumeCreateBody) types.Volume); ok {
+		r0 = rf(ctx, options)
+	} else {
+		r0 = ret.Get(0).(types.Volume)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, volume.VolumeCreateBody) error); ok {
+		r1 = rf(ctx, options)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// VolumeInspect provides a mock function with given fields: ctx, volumeID
+func (_m *VolumeAPIClient) VolumeInspect(ctx context.Context, volumeID string) (types.Volume, error) {
+	ret := _m.Called(ctx, volumeID)
+
+	var r0 types.Volume
+	if rf, ok := ret.Get(0).(func(context.Context, string) types.Volume); ok {
+		r0 = rf(ctx, volumeID)
+	} else {
+		r0 = ret.Get(0).(types.Volume)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, volumeID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// VolumeInspectWithRaw provides a mock function with given fields: ctx, volumeID
+func (_m *VolumeAPIClient) VolumeInspectWithRaw(ctx context.Context, volumeID string) (types.Volume, []byte, error) {
+	ret := _m.Called(ctx, volumeID)
+
+	var r0 types.Volume
+	if rf, ok := ret.Get(0).(func(context.Context, string) types.Volume); ok {
+		r0 = rf(ctx, volumeID)
+	} else {
+		r0 = ret.Get(0).(types.Volume)
+	}
+
+	var r1 []byte
+	if rf, ok := ret.Get(1).(func(context.Context, string) []byte); ok {
+		r1 = rf(ctx, volumeID)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).([]byte)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, string) error); ok {
+		r2 = rf(ctx, volumeID)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// VolumeList provides a mock function with given fields: ctx, filter
+func (_m *VolumeAPIClient) VolumeList(ctx context.Context, filter filters.Args) (volume.VolumeListOKBody, error) {
+	ret := _m.Called(ctx, filter)
+
+	var r0 volume.VolumeListOKBody
+	if rf, ok := ret.Get(0).(func(context.Context, filters.Args) volume.VolumeListOKBody); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		r0 = ret.Get(0).(volume.VolumeListOKBody)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, filters.Args) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// VolumeRemove provides a mock function with given fields: ctx, volumeID, force
+func (_m *VolumeAPIClient) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	ret := _m.Called(ctx, volumeID, force)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, bool) error); ok {
+		r0 = rf(ctx, volumeID, force)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// VolumesPrune provides a mock function with given fields: ctx, pruneFilter
+func (_m *VolumeAPIClient) VolumesPrune(ctx context.Context, pruneFilter filters.Args) (types.VolumesPruneReport, error) {
+	ret := _m.Called(ctx, pruneFilter)
+
+	var r0 types.VolumesPruneReport
+	if rf, ok := ret.Get(0).(func(context.Context, filters.Args) types.VolumesPruneReport); ok {
+		r0 = rf(ctx, pruneFilter)
+	} else {
+		r0 = ret.Get(0).(types.VolumesPruneReport)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, filters.Args) error); ok {
+		r1 = rf(ctx, pruneFilter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
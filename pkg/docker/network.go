@@ -0,0 +1,84 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/go-errors/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// CreateNetwork creates a user-defined bridge network with the supplied
+// labels together with the ankor labels, returning its ID.
+func (c *Client) CreateNetwork(ctx context.Context, name string, labels map[string]string) (string, error) {
+	if err := c.connect(); err != nil {
+		return "", err
+	}
+	return c.createNetwork(ctx, name, labels)
+}
+
+func (c *Client) createNetwork(ctx context.Context, name string, labels map[string]string) (string, error) {
+	log.Debug().Msgf("Creating network %s", name)
+	resp, err := c.networks.NetworkCreate(ctx, name, types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+		Labels:         c.withLabels(labels),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, 0)
+	}
+	return resp.ID, nil
+}
+
+// InspectNetwork returns the named network, or ErrNotFound.
+func (c *Client) InspectNetwork(ctx context.Context, name string) (types.NetworkResource, error) {
+	if err := c.connect(); err != nil {
+		return types.NetworkResource{}, err
+	}
+	return c.inspectNetwork(ctx, name)
+}
+
+func (c *Client) inspectNetwork(ctx context.Context, name string) (types.NetworkResource, error) {
+	network, err := c.networks.NetworkInspect(ctx, name, types.NetworkInspectOptions{})
+	if client.IsErrNotFound(err) {
+		return network, errors.New(fmt.Errorf("network '%s' %w", name, ErrNotFound))
+	}
+	if err != nil {
+		return network, errors.Wrap(err, 0)
+	}
+	return network, nil
+}
+
+// RemoveNetwork removes the named network, which must not have containers
+// connected. Removing a missing network succeeds.
+func (c *Client) RemoveNetwork(ctx context.Context, name string) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+	return c.removeNetwork(ctx, name)
+}
+
+func (c *Client) removeNetwork(ctx context.Context, name string) error {
+	log.Debug().Msgf("Removing network %s", name)
+	if err := c.networks.NetworkRemove(ctx, name); err != nil && !client.IsErrNotFound(err) {
+		return errors.Wrap(err, 0)
+	}
+	return nil
+}
+
+// ListNetworks returns the networks created by ankor.
+func (c *Client) ListNetworks(ctx context.Context) ([]types.NetworkResource, error) {
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	networks, err := c.networks.NetworkList(ctx, types.NetworkListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", LabelManaged+"=true")),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+	return networks, nil
+}
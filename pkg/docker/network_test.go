@@ -0,0 +1,78 @@
+package docker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ankorstore/ankorstore-cli-modules/pkg/docker/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+	"github.com/go-errors/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func networkClient(n *mocks.NetworkAPIClient) *Client {
+	d, _ := NewClient(
+		WithContainerClient(&mocks.ContainerAPIClient{}),
+		WithImageClient(&mocks.ImageAPIClient{}),
+		WithNetworkClient(n))
+	return d
+}
+
+func TestNetworks(t *testing.T) {
+	t.Run("creates bridge networks labelled as ankor-owned", func(t *testing.T) {
+		n := &mocks.NetworkAPIClient{}
+		n.On("NetworkCreate", mock.Anything, "ankor_e2e", mock.MatchedBy(func(opts types.NetworkCreate) bool {
+			return opts.CheckDuplicate && opts.Driver == "bridge" &&
+				opts.Labels[LabelManaged] == "true" && opts.Labels["team"] == "platform"
+		})).
+			Once().
+			Return(types.NetworkCreateResponse{ID: "net123"}, nil)
+
+		id, err := networkClient(n).CreateNetwork(context.Background(), "ankor_e2e", map[string]string{"team": "platform"})
+		assert.NoError(t, err)
+		assert.Equal(t, "net123", id)
+		n.AssertExpectations(t)
+	})
+
+	t.Run("inspects networks", func(t *testing.T) {
+		n := &mocks.NetworkAPIClient{}
+		n.On("NetworkInspect", mock.Anything, "ankor_e2e", types.NetworkInspectOptions{}).Once().Return(types.NetworkResource{ID: "net123"}, nil)
+		n.On("NetworkInspect", mock.Anything, "missing", types.NetworkInspectOptions{}).
+			Once().
+			Return(types.NetworkResource{}, errdefs.NotFound(errors.New("no such network")))
+
+		d := networkClient(n)
+		network, err := d.InspectNetwork(context.Background(), "ankor_e2e")
+		assert.NoError(t, err)
+		assert.Equal(t, "net123", network.ID)
+
+		_, err = d.InspectNetwork(context.Background(), "missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("removes networks", func(t *testing.T) {
+		n := &mocks.NetworkAPIClient{}
+		n.On("NetworkRemove", mock.Anything, "ankor_e2e").Once().Return(nil)
+		n.On("NetworkRemove", mock.Anything, "missing").Once().Return(errdefs.NotFound(errors.New("no such network")))
+		n.On("NetworkRemove", mock.Anything, "in-use").Once().Return(errdefs.Forbidden(errors.New("network has active endpoints")))
+
+		d := networkClient(n)
+		assert.NoError(t, d.RemoveNetwork(context.Background(), "ankor_e2e"))
+		assert.NoError(t, d.RemoveNetwork(context.Background(), "missing"))
+		assert.ErrorContains(t, d.RemoveNetwork(context.Background(), "in-use"), "active endpoints")
+	})
+
+	t.Run("lists ankor networks", func(t *testing.T) {
+		n := &mocks.NetworkAPIClient{}
+		n.On("NetworkList", mock.Anything, types.NetworkListOptions{Filters: filters.NewArgs(filters.Arg("label", LabelManaged+"=true"))}).
+			Once().
+			Return([]types.NetworkResource{{Name: "ankor_e2e"}}, nil)
+
+		networks, err := networkClient(n).ListNetworks(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "ankor_e2e", networks[0].Name)
+	})
+}
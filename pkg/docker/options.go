@@ -2,20 +2,47 @@ package docker
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
 	"github.com/go-errors/errors"
 	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/subosito/gotenv"
 )
 
+// DefaultStopTimeout is how long an interrupted container is given to stop
+// before it is killed, unless overridden with RunWithStopTimeout.
+const DefaultStopTimeout = 10 * time.Second
+
 var (
 	ErrCannotRedeclare = errors.New("cannot be declared more than once")
+	ErrConflict        = errors.New("cannot be combined with")
+	ErrMissingOption   = errors.New("must be declared")
 )
 
 type RunOpt func(*RunConfig) error
 
+// PullPolicy decides whether Run pulls its image before creating the
+// container.
+type PullPolicy string
+
+const (
+	// PullAlways pulls the image on every run.
+	PullAlways PullPolicy = "always"
+	// PullIfNotPresent pulls the image when the daemon does not have it.
+	PullIfNotPresent PullPolicy = "if-not-present"
+	// PullNever uses the image of the daemon, failing when it is missing.
+	PullNever PullPolicy = "never"
+)
+
 type RunCommand interface {
 	GetCommand() ([]string, error)
 }
@@ -26,6 +53,98 @@ type RunConfig struct {
 	NetworkConfig *network.NetworkingConfig
 	Platform      *specs.Platform
 	Name          string
+	Streams
+	AutoRemove  *bool
+	PullPolicy  PullPolicy
+	HealthProbe HealthProbe
+	StopTimeout *time.Duration
+	declarations
+}
+
+// newRunConfig applies the supplied options and validates the result.
+func newRunConfig(opts ...RunOpt) (*RunConfig, error) {
+	cfg := &RunConfig{}
+	for _, o := range opts {
+		if err := o(cfg); err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// declarations records the single use options applied to a config.
+type declarations map[string]bool
+
+// declare records that the named option has been applied, failing if it
+// already was.
+func (d *declarations) declare(option string) error {
+	if *d == nil {
+		*d = declarations{}
+	}
+	if (*d)[option] {
+		return errors.New(fmt.Errorf("'%s' %w", option, ErrCannotRedeclare))
+	}
+	(*d)[option] = true
+	return nil
+}
+
+// validate checks for missing options and options that cannot be combined.
+func (cfg *RunConfig) validate() error {
+	if cfg.Config == nil || cfg.Config.Image == "" {
+		return errors.New(fmt.Errorf("'Image' %w", ErrMissingOption))
+	}
+	if cfg.AutoRemove != nil && *cfg.AutoRemove && cfg.restarts() {
+		return conflictError("AutoRemove", "RestartPolicy")
+	}
+	if cfg.HostConfig != nil && cfg.HostConfig.NetworkMode.IsHost() && len(cfg.HostConfig.PortBindings) > 0 {
+		return conflictError("Ports", "Network 'host'")
+	}
+	if cfg.HostConfig != nil {
+		targets := map[string]bool{}
+		for _, m := range cfg.HostConfig.Mounts {
+			if targets[m.Target] {
+				return errors.New(fmt.Errorf("mount target '%s' %w", m.Target, ErrCannotRedeclare))
+			}
+			targets[m.Target] = true
+		}
+	}
+	if p, ok := cfg.HealthProbe.(*tcpProbe); ok && (cfg.HostConfig == nil || len(cfg.HostConfig.PortBindings[p.port]) == 0) {
+		return errors.New(fmt.Errorf("'Ports' %w to probe port '%s'", ErrMissingOption, p.port.Port()))
+	}
+	return nil
+}
+
+func conflictError(option, other string) error {
+	return errors.New(fmt.Errorf("'%s' %w '%s'", option, ErrConflict, other))
+}
+
+func (cfg *RunConfig) autoRemove() bool {
+	if cfg.AutoRemove == nil {
+		// the daemon refuses to auto remove containers it may restart
+		return !cfg.restarts()
+	}
+	return *cfg.AutoRemove
+}
+
+func (cfg *RunConfig) stopTimeout() time.Duration {
+	if cfg.StopTimeout == nil {
+		return DefaultStopTimeout
+	}
+	return *cfg.StopTimeout
+}
+
+func (cfg *RunConfig) pullPolicy() PullPolicy {
+	if cfg.PullPolicy == "" {
+		return PullIfNotPresent
+	}
+	return cfg.PullPolicy
+}
+
+func (cfg *RunConfig) restarts() bool {
+	return cfg.HostConfig != nil && !cfg.HostConfig.RestartPolicy.IsNone()
 }
 
 func initRunConfig(cfg *RunConfig) {
@@ -40,8 +159,20 @@ func initRunHostConfig(cfg *RunConfig) {
 	}
 }
 
+func initRunNetworkConfig(cfg *RunConfig) {
+	if cfg.NetworkConfig == nil {
+		cfg.NetworkConfig = &network.NetworkingConfig{}
+	}
+	if cfg.NetworkConfig.EndpointsConfig == nil {
+		cfg.NetworkConfig.EndpointsConfig = map[string]*network.EndpointSettings{}
+	}
+}
+
 func RunWithImage(image string) RunOpt {
 	return func(cfg *RunConfig) error {
+		if err := cfg.declare("Image"); err != nil {
+			return err
+		}
 		initRunConfig(cfg)
 		cfg.Config.Image = image
 		return nil
@@ -50,6 +181,9 @@ func RunWithImage(image string) RunOpt {
 
 func RunWithWorkingDir(dir string) RunOpt {
 	return func(cfg *RunConfig) error {
+		if err := cfg.declare("WorkingDir"); err != nil {
+			return err
+		}
 		initRunConfig(cfg)
 		cfg.Config.WorkingDir = dir
 		return nil
@@ -58,6 +192,9 @@ func RunWithWorkingDir(dir string) RunOpt {
 
 func RunWithEntrypoint(entrypoint []string) RunOpt {
 	return func(cfg *RunConfig) error {
+		if err := cfg.declare("Entrypoint"); err != nil {
+			return err
+		}
 		initRunConfig(cfg)
 		cfg.Config.Entrypoint = entrypoint
 		return nil
@@ -66,30 +203,381 @@ func RunWithEntrypoint(entrypoint []string) RunOpt {
 
 func RunWithCommand(cmd []string) RunOpt {
 	return func(cfg *RunConfig) error {
+		if err := cfg.declare("Command"); err != nil {
+			return err
+		}
 		initRunConfig(cfg)
 		cfg.Config.Cmd = cmd
 		return nil
 	}
 }
 
+// RunWithOutput sets the writers the container's stdout and stderr are
+// streamed to while it runs. Defaults to os.Stdout and os.Stderr.
+func RunWithOutput(stdout, stderr io.Writer) RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("Output"); err != nil {
+			return err
+		}
+		cfg.Stdout = stdout
+		cfg.Stderr = stderr
+		return nil
+	}
+}
+
+// RunWithTTY allocates a pseudo-TTY for the container. When stdin is also
+// attached from a terminal, that terminal is put into raw mode for the
+// duration of the run and its size is kept in sync with the container.
+func RunWithTTY() RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("TTY"); err != nil {
+			return err
+		}
+		initRunConfig(cfg)
+		cfg.Config.Tty = true
+		return nil
+	}
+}
+
+// RunWithStdin attaches in to the container's stdin, e.g. os.Stdin for an
+// interactive shell.
+func RunWithStdin(in io.Reader) RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("Stdin"); err != nil {
+			return err
+		}
+		initRunConfig(cfg)
+		cfg.Stdin = in
+		cfg.Config.AttachStdin = true
+		cfg.Config.OpenStdin = true
+		cfg.Config.StdinOnce = true
+		return nil
+	}
+}
+
+// RunWithAutoRemove sets whether the container is removed once it exits.
+// Containers are removed by default; pass false to keep it for debugging.
+func RunWithAutoRemove(enabled bool) RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("AutoRemove"); err != nil {
+			return err
+		}
+		cfg.AutoRemove = &enabled
+		return nil
+	}
+}
+
+// RunWithRestartPolicy sets the restart policy of the container, one of
+// "no", "always", "unless-stopped" or "on-failure". maxRetries only applies
+// to "on-failure".
+func RunWithRestartPolicy(name string, maxRetries int) RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("RestartPolicy"); err != nil {
+			return err
+		}
+		policy := container.RestartPolicy{Name: name}
+		switch {
+		case policy.IsOnFailure():
+			policy.MaximumRetryCount = maxRetries
+		case policy.IsNone(), policy.IsAlways(), policy.IsUnlessStopped():
+		default:
+			return errors.New(fmt.Errorf("invalid restart policy '%s'", name))
+		}
+		initRunHostConfig(cfg)
+		cfg.HostConfig.RestartPolicy = policy
+		return nil
+	}
+}
+
+// RunWithPullPolicy sets whether the image is pulled before running the
+// container. Defaults to PullIfNotPresent, as docker run --pull=missing.
+func RunWithPullPolicy(policy PullPolicy) RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("PullPolicy"); err != nil {
+			return err
+		}
+		switch policy {
+		case PullAlways, PullIfNotPresent, PullNever:
+		default:
+			return errors.New(fmt.Errorf("invalid pull policy '%s'", policy))
+		}
+		cfg.PullPolicy = policy
+		return nil
+	}
+}
+
+// RunWithHealthCheck sets the HEALTHCHECK of the container, overriding the
+// one of the image.
+func RunWithHealthCheck(healthCheck *container.HealthConfig) RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("HealthCheck"); err != nil {
+			return err
+		}
+		initRunConfig(cfg)
+		cfg.Config.Healthcheck = healthCheck
+		return nil
+	}
+}
+
+// RunWithHealthProbe sets how Container.WaitHealthy decides a detached
+// container is ready, instead of its HEALTHCHECK.
+func RunWithHealthProbe(probe HealthProbe) RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("HealthProbe"); err != nil {
+			return err
+		}
+		if probe == nil {
+			return errors.New("'HealthProbe' cannot be nil")
+		}
+		cfg.HealthProbe = probe
+		return nil
+	}
+}
+
+// RunWithStopTimeout sets how long the container is given to stop when its
+// run is interrupted before it is killed. Defaults to DefaultStopTimeout.
+func RunWithStopTimeout(timeout time.Duration) RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("StopTimeout"); err != nil {
+			return err
+		}
+		if timeout < 0 {
+			return errors.New(fmt.Errorf("'StopTimeout' must not be negative, got %s", timeout))
+		}
+		initRunConfig(cfg)
+		seconds := int(timeout.Seconds())
+		cfg.Config.StopTimeout = &seconds
+		cfg.StopTimeout = &timeout
+		return nil
+	}
+}
+
+// RunWithExitLogLines sets how many trailing output lines are kept on the
+// ContainerExitError returned when the container fails.
+func RunWithExitLogLines(n int) RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("ExitLogLines"); err != nil {
+			return err
+		}
+		if n < 0 {
+			return errors.New(fmt.Errorf("'ExitLogLines' must not be negative, got %d", n))
+		}
+		cfg.ExitLogLines = &n
+		return nil
+	}
+}
+
+// RunWithMounts adds the supplied mounts to the container, next to any
+// volume declared with RunWithVolume or RunWithCacheVolume.
 func RunWithMounts(mounts []mount.Mount) RunOpt {
 	return func(cfg *RunConfig) error {
+		if err := cfg.declare("Mounts"); err != nil {
+			return err
+		}
 		initRunHostConfig(cfg)
-		if cfg.Name != "" {
-			return errors.New(fmt.Errorf("'Mounts' %w", ErrCannotRedeclare))
+		cfg.HostConfig.Mounts = append(cfg.HostConfig.Mounts, mounts...)
+		return nil
+	}
+}
+
+// RunWithVolume mounts the named volume at target, the daemon creating the
+// volume when it does not exist yet.
+func RunWithVolume(name, target string) RunOpt {
+	return runWithVolume(name, target, map[string]string{LabelManaged: "true"})
+}
+
+// RunWithCacheVolume mounts the named volume at target to keep a dependency
+// cache across runs, e.g. RunWithCacheVolume("ankor-go-mod", "/go/pkg/mod").
+// The volume is labelled so cache volumes can be listed and pruned.
+func RunWithCacheVolume(name, target string) RunOpt {
+	return runWithVolume(name, target, map[string]string{LabelManaged: "true", LabelCache: "true"})
+}
+
+func runWithVolume(name, target string, labels map[string]string) RunOpt {
+	return func(cfg *RunConfig) error {
+		if name == "" || target == "" {
+			return errors.New(fmt.Errorf("'Volume' needs a name and a target, got '%s:%s'", name, target))
 		}
-		cfg.HostConfig.Mounts = mounts
+		initRunHostConfig(cfg)
+		cfg.HostConfig.Mounts = append(cfg.HostConfig.Mounts, mount.Mount{
+			Type:          mount.TypeVolume,
+			Source:        name,
+			Target:        target,
+			VolumeOptions: &mount.VolumeOptions{Labels: labels},
+		})
 		return nil
 	}
 }
 
 func RunWithShell(shell []string) RunOpt {
 	return func(cfg *RunConfig) error {
-		initRunConfig(cfg)
-		if cfg.Name != "" {
-			return errors.New(fmt.Errorf("'Mounts' %w", ErrCannotRedeclare))
+		if err := cfg.declare("Shell"); err != nil {
+			return err
 		}
+		initRunConfig(cfg)
 		cfg.Config.Shell = shell
 		return nil
 	}
 }
+
+// RunWithName sets the container name.
+func RunWithName(name string) RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("Name"); err != nil {
+			return err
+		}
+		cfg.Name = name
+		return nil
+	}
+}
+
+// RunWithEnv adds the supplied variables to the container environment.
+func RunWithEnv(env map[string]string) RunOpt {
+	return func(cfg *RunConfig) error {
+		initRunConfig(cfg)
+		keys := make([]string, 0, len(env))
+		for k := range env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			cfg.Config.Env = append(cfg.Config.Env, fmt.Sprintf("%s=%s", k, env[k]))
+		}
+		return nil
+	}
+}
+
+// RunWithEnvFile adds the variables declared in a .env file to the container
+// environment.
+func RunWithEnvFile(path string) RunOpt {
+	return func(cfg *RunConfig) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+		defer func() { _ = f.Close() }()
+
+		env, err := gotenv.StrictParse(f)
+		if err != nil {
+			return errors.WrapPrefix(err, fmt.Sprintf("parsing env file %s", path), 0)
+		}
+		return RunWithEnv(env)(cfg)
+	}
+}
+
+// RunWithPorts publishes container ports using the same format as
+// `docker run -p`, e.g. "8080:80" or "127.0.0.1:5432:5432/tcp".
+func RunWithPorts(ports ...string) RunOpt {
+	return func(cfg *RunConfig) error {
+		initRunConfig(cfg)
+		initRunHostConfig(cfg)
+		exposed, bindings, err := nat.ParsePortSpecs(ports)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+		if cfg.Config.ExposedPorts == nil {
+			cfg.Config.ExposedPorts = nat.PortSet{}
+		}
+		if cfg.HostConfig.PortBindings == nil {
+			cfg.HostConfig.PortBindings = nat.PortMap{}
+		}
+		for p := range exposed {
+			cfg.Config.ExposedPorts[p] = struct{}{}
+		}
+		for p, b := range bindings {
+			cfg.HostConfig.PortBindings[p] = append(cfg.HostConfig.PortBindings[p], b...)
+		}
+		return nil
+	}
+}
+
+// RunWithUser sets the user the container process runs as, e.g. "1000:1000".
+func RunWithUser(user string) RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("User"); err != nil {
+			return err
+		}
+		initRunConfig(cfg)
+		cfg.Config.User = user
+		return nil
+	}
+}
+
+// RunWithNetwork connects the container to the named network, optionally
+// reachable by the supplied aliases.
+func RunWithNetwork(name string, aliases ...string) RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("Network"); err != nil {
+			return err
+		}
+		initRunHostConfig(cfg)
+		initRunNetworkConfig(cfg)
+		cfg.HostConfig.NetworkMode = container.NetworkMode(name)
+		cfg.NetworkConfig.EndpointsConfig[name] = &network.EndpointSettings{Aliases: aliases}
+		return nil
+	}
+}
+
+// RunWithLabels adds the supplied labels to the container.
+func RunWithLabels(labels map[string]string) RunOpt {
+	return func(cfg *RunConfig) error {
+		initRunConfig(cfg)
+		if cfg.Config.Labels == nil {
+			cfg.Config.Labels = map[string]string{}
+		}
+		for k, v := range labels {
+			cfg.Config.Labels[k] = v
+		}
+		return nil
+	}
+}
+
+// RunWithPlatform selects the image platform as os/arch[/variant], e.g.
+// "linux/amd64" or "linux/arm64/v8".
+func RunWithPlatform(platform string) RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("Platform"); err != nil {
+			return err
+		}
+		parts := strings.Split(platform, "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return errors.New(fmt.Errorf("invalid platform '%s', expected os/arch[/variant]", platform))
+		}
+		cfg.Platform = &specs.Platform{OS: parts[0], Architecture: parts[1]}
+		if len(parts) == 3 {
+			cfg.Platform.Variant = parts[2]
+		}
+		return nil
+	}
+}
+
+// RunWithCPUs limits the number of CPUs available to the container, e.g. 1.5.
+func RunWithCPUs(cpus float64) RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("CPUs"); err != nil {
+			return err
+		}
+		if cpus <= 0 {
+			return errors.New(fmt.Errorf("'CPUs' must be positive, got %g", cpus))
+		}
+		initRunHostConfig(cfg)
+		cfg.HostConfig.NanoCPUs = int64(cpus * 1e9)
+		return nil
+	}
+}
+
+// RunWithMemory limits the memory available to the container, e.g. "512m".
+func RunWithMemory(limit string) RunOpt {
+	return func(cfg *RunConfig) error {
+		if err := cfg.declare("Memory"); err != nil {
+			return err
+		}
+		bytes, err := units.RAMInBytes(limit)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+		initRunHostConfig(cfg)
+		cfg.HostConfig.Memory = bytes
+		return nil
+	}
+}
@@ -0,0 +1,269 @@
+package docker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+)
+
+func applyRunOpts(t *testing.T, opts ...RunOpt) *RunConfig {
+	cfg := &RunConfig{}
+	for _, o := range opts {
+		assert.NoError(t, o(cfg))
+	}
+	return cfg
+}
+
+func TestRunWithEnv(t *testing.T) {
+	t.Run("from a map", func(t *testing.T) {
+		cfg := applyRunOpts(t, RunWithEnv(map[string]string{"B": "2", "A": "1"}))
+		assert.Equal(t, []string{"A=1", "B=2"}, cfg.Config.Env)
+	})
+
+	t.Run("from an env file", func(t *testing.T) {
+		cfg := applyRunOpts(t, RunWithEnvFile("./testdata/test.env"))
+		assert.Equal(t, []string{"APP_ENV=test", "DB_HOST=localhost", "DB_PASSWORD=s3cr3t value"}, cfg.Config.Env)
+	})
+
+	t.Run("with a missing env file", func(t *testing.T) {
+		err := RunWithEnvFile("./testdata/missing.env")(&RunConfig{})
+		assert.Error(t, err)
+	})
+}
+
+func TestRunWithPorts(t *testing.T) {
+	cfg := applyRunOpts(t, RunWithPorts("8080:80", "127.0.0.1:5432:5432/tcp"))
+
+	assert.Contains(t, cfg.Config.ExposedPorts, nat.Port("80/tcp"))
+	assert.Contains(t, cfg.Config.ExposedPorts, nat.Port("5432/tcp"))
+	assert.Equal(t, []nat.PortBinding{{HostPort: "8080"}}, cfg.HostConfig.PortBindings["80/tcp"])
+	assert.Equal(t, []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: "5432"}}, cfg.HostConfig.PortBindings["5432/tcp"])
+
+	err := RunWithPorts("not-a-port")(&RunConfig{})
+	assert.Error(t, err)
+}
+
+func TestRunWithNetwork(t *testing.T) {
+	cfg := applyRunOpts(t, RunWithNetwork("ankor", "db", "postgres"))
+
+	assert.Equal(t, container.NetworkMode("ankor"), cfg.HostConfig.NetworkMode)
+	assert.Equal(t, []string{"db", "postgres"}, cfg.NetworkConfig.EndpointsConfig["ankor"].Aliases)
+}
+
+func TestRunWithPlatform(t *testing.T) {
+	cfg := applyRunOpts(t, RunWithPlatform("linux/arm64/v8"))
+	assert.Equal(t, "linux", cfg.Platform.OS)
+	assert.Equal(t, "arm64", cfg.Platform.Architecture)
+	assert.Equal(t, "v8", cfg.Platform.Variant)
+
+	for _, p := range []string{"linux", "/amd64", "linux/amd64/v8/extra"} {
+		assert.Error(t, RunWithPlatform(p)(&RunConfig{}), p)
+	}
+}
+
+func TestRunWithResources(t *testing.T) {
+	cfg := applyRunOpts(t, RunWithCPUs(1.5), RunWithMemory("512m"))
+	assert.Equal(t, int64(1500000000), cfg.HostConfig.NanoCPUs)
+	assert.Equal(t, int64(512*1024*1024), cfg.HostConfig.Memory)
+
+	assert.Error(t, RunWithCPUs(0)(&RunConfig{}))
+	assert.Error(t, RunWithMemory("lots")(&RunConfig{}))
+}
+
+func TestRunWithMiscOptions(t *testing.T) {
+	cfg := applyRunOpts(t,
+		RunWithName("ankor_test"),
+		RunWithUser("1000:1000"),
+		RunWithLabels(map[string]string{"team": "platform"}))
+
+	assert.Equal(t, "ankor_test", cfg.Name)
+	assert.Equal(t, "1000:1000", cfg.Config.User)
+	assert.Equal(t, "platform", cfg.Config.Labels["team"])
+}
+
+func TestRunConfigValidation(t *testing.T) {
+	cases := []struct {
+		label    string
+		opts     []RunOpt
+		expected error
+		message  string
+	}{
+		{
+			label: "with the minimum options",
+			opts:  []RunOpt{RunWithImage("busybox")},
+		},
+		{
+			label:    "without an image",
+			opts:     []RunOpt{RunWithCommand([]string{"ls"})},
+			expected: ErrMissingOption,
+			message:  "'Image' must be declared",
+		},
+		{
+			label:    "with the image declared twice",
+			opts:     []RunOpt{RunWithImage("busybox"), RunWithImage("alpine")},
+			expected: ErrCannotRedeclare,
+			message:  "'Image' cannot be declared more than once",
+		},
+		{
+			label:    "with mounts declared twice",
+			opts:     []RunOpt{RunWithImage("busybox"), RunWithMounts(nil), RunWithMounts(nil)},
+			expected: ErrCannotRedeclare,
+			message:  "'Mounts' cannot be declared more than once",
+		},
+		{
+			label:    "with shell declared twice",
+			opts:     []RunOpt{RunWithImage("busybox"), RunWithShell([]string{"sh"}), RunWithShell([]string{"bash"})},
+			expected: ErrCannotRedeclare,
+			message:  "'Shell' cannot be declared more than once",
+		},
+		{
+			label: "with a name and mounts",
+			opts:  []RunOpt{RunWithImage("busybox"), RunWithName("ankor_test"), RunWithMounts(nil), RunWithShell([]string{"sh"})},
+		},
+		{
+			label: "with additive options declared more than once",
+			opts: []RunOpt{
+				RunWithImage("busybox"),
+				RunWithEnv(map[string]string{"A": "1"}),
+				RunWithEnv(map[string]string{"B": "2"}),
+				RunWithPorts("80"),
+				RunWithPorts("443"),
+			},
+		},
+		{
+			label:    "with auto remove and a restart policy",
+			opts:     []RunOpt{RunWithImage("busybox"), RunWithAutoRemove(true), RunWithRestartPolicy("always", 0)},
+			expected: ErrConflict,
+			message:  "'AutoRemove' cannot be combined with 'RestartPolicy'",
+		},
+		{
+			label: "with auto remove and a 'no' restart policy",
+			opts:  []RunOpt{RunWithImage("busybox"), RunWithAutoRemove(true), RunWithRestartPolicy("no", 0)},
+		},
+		{
+			label:    "with published ports on the host network",
+			opts:     []RunOpt{RunWithImage("busybox"), RunWithNetwork("host"), RunWithPorts("80:80")},
+			expected: ErrConflict,
+			message:  "'Ports' cannot be combined with 'Network 'host''",
+		},
+		{
+			label: "with a volume mounted over mounts",
+			opts: []RunOpt{
+				RunWithImage("golang"),
+				RunWithCacheVolume("ankor-go-mod", "/go/pkg/mod"),
+				RunWithMounts([]mount.Mount{{Type: mount.TypeBind, Source: "/mod", Target: "/go/pkg/mod"}}),
+			},
+			expected: ErrCannotRedeclare,
+			message:  "mount target '/go/pkg/mod' cannot be declared more than once",
+		},
+		{
+			label: "with mounts mounted over a volume",
+			opts: []RunOpt{
+				RunWithImage("golang"),
+				RunWithMounts([]mount.Mount{{Type: mount.TypeBind, Source: "/mod", Target: "/go/pkg/mod"}}),
+				RunWithCacheVolume("ankor-go-mod", "/go/pkg/mod"),
+			},
+			expected: ErrCannotRedeclare,
+			message:  "mount target '/go/pkg/mod' cannot be declared more than once",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.label, func(t *testing.T) {
+			_, err := newRunConfig(c.opts...)
+			if c.expected == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, c.expected)
+			assert.Equal(t, c.message, err.Error())
+		})
+	}
+}
+
+func TestRunWithRestartPolicy(t *testing.T) {
+	cfg, err := newRunConfig(RunWithImage("busybox"), RunWithRestartPolicy("on-failure", 3))
+	assert.NoError(t, err)
+	assert.Equal(t, container.RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}, cfg.HostConfig.RestartPolicy)
+	assert.False(t, cfg.autoRemove(), "containers that restart are not auto removed by default")
+
+	_, err = newRunConfig(RunWithImage("busybox"), RunWithRestartPolicy("sometimes", 0))
+	assert.Error(t, err)
+}
+
+func TestRunWithPullPolicy(t *testing.T) {
+	cfg, err := newRunConfig(RunWithImage("busybox"))
+	assert.NoError(t, err)
+	assert.Equal(t, PullIfNotPresent, cfg.pullPolicy())
+
+	cfg, err = newRunConfig(RunWithImage("busybox"), RunWithPullPolicy(PullNever))
+	assert.NoError(t, err)
+	assert.Equal(t, PullNever, cfg.pullPolicy())
+
+	_, err = newRunConfig(RunWithImage("busybox"), RunWithPullPolicy("sometimes"))
+	assert.Error(t, err)
+}
+
+func TestRunWithHealthCheck(t *testing.T) {
+	healthCheck := &container.HealthConfig{Test: []string{"CMD", "pg_isready"}, Interval: time.Second}
+	cfg, err := newRunConfig(RunWithImage("postgres"), RunWithPorts("5432"), RunWithHealthCheck(healthCheck), RunWithHealthProbe(TCPProbe("5432")))
+	assert.NoError(t, err)
+	assert.Equal(t, healthCheck, cfg.Config.Healthcheck)
+	assert.Equal(t, TCPProbe("5432"), cfg.HealthProbe)
+
+	_, err = newRunConfig(RunWithImage("postgres"), RunWithHealthProbe(TCPProbe("5432")))
+	assert.ErrorIs(t, err, ErrMissingOption)
+	assert.ErrorContains(t, err, "'Ports' must be declared to probe port '5432'")
+
+	_, err = newRunConfig(RunWithImage("postgres"), RunWithHealthProbe(nil))
+	assert.Error(t, err)
+}
+
+func TestRunWithVolumes(t *testing.T) {
+	cfg, err := newRunConfig(
+		RunWithImage("golang"),
+		RunWithCacheVolume("ankor-go-mod", "/go/pkg/mod"),
+		RunWithMounts([]mount.Mount{{Type: mount.TypeBind, Source: "/src", Target: "/src"}}),
+		RunWithVolume("ankor-data", "/data"))
+	assert.NoError(t, err)
+	assert.Equal(t, []mount.Mount{
+		{
+			Type:          mount.TypeVolume,
+			Source:        "ankor-go-mod",
+			Target:        "/go/pkg/mod",
+			VolumeOptions: &mount.VolumeOptions{Labels: map[string]string{LabelManaged: "true", LabelCache: "true"}},
+		},
+		{Type: mount.TypeBind, Source: "/src", Target: "/src"},
+		{
+			Type:          mount.TypeVolume,
+			Source:        "ankor-data",
+			Target:        "/data",
+			VolumeOptions: &mount.VolumeOptions{Labels: map[string]string{LabelManaged: "true"}},
+		},
+	}, cfg.HostConfig.Mounts)
+
+	_, err = newRunConfig(RunWithImage("golang"), RunWithCacheVolume("a", "/cache"), RunWithCacheVolume("b", "/cache"))
+	assert.ErrorIs(t, err, ErrCannotRedeclare)
+
+	_, err = newRunConfig(RunWithImage("golang"), RunWithCacheVolume("", "/cache"))
+	assert.Error(t, err)
+}
+
+func TestRunWithStopTimeout(t *testing.T) {
+	cfg, err := newRunConfig(RunWithImage("postgres"))
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultStopTimeout, cfg.stopTimeout())
+	assert.Nil(t, cfg.Config.StopTimeout)
+
+	cfg, err = newRunConfig(RunWithImage("postgres"), RunWithStopTimeout(30*time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, cfg.stopTimeout())
+	assert.Equal(t, 30, *cfg.Config.StopTimeout)
+
+	_, err = newRunConfig(RunWithImage("postgres"), RunWithStopTimeout(-time.Second))
+	assert.Error(t, err)
+}
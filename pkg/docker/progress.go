@@ -0,0 +1,271 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/go-units"
+	"github.com/go-errors/errors"
+	"github.com/moby/term"
+	"github.com/rs/zerolog/log"
+)
+
+type ProgressMode string
+
+const (
+	// ProgressAuto renders progress bars when the progress output is a
+	// terminal outside of CI, and summary lines otherwise.
+	ProgressAuto ProgressMode = "auto"
+	// ProgressTTY renders per-layer progress bars.
+	ProgressTTY ProgressMode = "tty"
+	// ProgressPlain logs a summary line at most once per progress interval.
+	ProgressPlain ProgressMode = "plain"
+	// ProgressNone renders nothing, leaving only the debug output.
+	ProgressNone ProgressMode = "none"
+
+	DefaultProgressInterval = 10 * time.Second
+)
+
+// Progress is an update of a layer during a pull or push, or of a step
+// during a build.
+type Progress struct {
+	// ID identifies the layer or build step, and is empty for messages
+	// about the whole operation.
+	ID      string
+	Status  string
+	Current int64
+	Total   int64
+}
+
+// ProgressFunc receives every progress update of pulls, pushes and builds.
+type ProgressFunc func(Progress)
+
+// WithProgressMode sets how progress is rendered. Defaults to ProgressAuto.
+func WithProgressMode(mode ProgressMode) ClientOpt {
+	return func(c *Client) error {
+		switch mode {
+		case ProgressAuto, ProgressTTY, ProgressPlain, ProgressNone:
+			c.progressMode = mode
+			return nil
+		}
+		return errors.New(fmt.Errorf("unknown progress mode '%s'", mode))
+	}
+}
+
+// WithProgressOutput sets where progress bars are written. Defaults to
+// os.Stderr.
+func WithProgressOutput(out io.Writer) ClientOpt {
+	return func(c *Client) error {
+		c.progressOut = out
+		return nil
+	}
+}
+
+// WithProgressInterval sets how often summary lines are logged in plain
+// mode. Defaults to DefaultProgressInterval.
+func WithProgressInterval(interval time.Duration) ClientOpt {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return errors.New(fmt.Errorf("progress interval must be positive, got %s", interval))
+		}
+		c.progressInterval = interval
+		return nil
+	}
+}
+
+// WithProgressFunc registers a callback receiving every progress update,
+// whatever the progress mode.
+func WithProgressFunc(fn ProgressFunc) ClientOpt {
+	return func(c *Client) error {
+		c.progressFunc = fn
+		return nil
+	}
+}
+
+// progress renders the updates of a single pull, push or build.
+type progress struct {
+	fn    ProgressFunc
+	tty   *ttyProgress
+	plain *plainProgress
+}
+
+// newProgress starts rendering an operation described by title, e.g.
+// "Pulling busybox".
+func (c *Client) newProgress(title string) *progress {
+	p := &progress{fn: c.progressFunc}
+	mode := c.progressMode
+	fd, isTerminal := term.GetFdInfo(c.progressOut)
+	if mode == ProgressAuto {
+		mode = ProgressPlain
+		if isTerminal && os.Getenv("CI") == "" {
+			mode = ProgressTTY
+		}
+	}
+	switch mode {
+	case ProgressTTY:
+		p.tty = newTTYProgress(c.progressOut, fd, isTerminal)
+	case ProgressPlain:
+		p.plain = newPlainProgress(title, c.progressInterval)
+	}
+	return p
+}
+
+func (p *progress) update(msg jsonmessage.JSONMessage) {
+	if p == nil {
+		return
+	}
+	if p.fn != nil {
+		update := Progress{ID: msg.ID, Status: msg.Status}
+		if msg.Stream != "" {
+			update.Status = strings.TrimSuffix(msg.Stream, "\n")
+		}
+		if msg.Progress != nil {
+			update.Current, update.Total = msg.Progress.Current, msg.Progress.Total
+		}
+		p.fn(update)
+	}
+	if p.tty != nil {
+		p.tty.update(msg)
+	}
+	if p.plain != nil {
+		p.plain.update(msg)
+	}
+}
+
+func (p *progress) close() {
+	if p == nil {
+		return
+	}
+	if p.tty != nil {
+		p.tty.close()
+	}
+	if p.plain != nil {
+		p.plain.close()
+	}
+}
+
+// ttyProgress draws progress bars with the docker CLI renderer, which reads
+// the messages back from a pipe.
+type ttyProgress struct {
+	pw   *io.PipeWriter
+	enc  *json.Encoder
+	done chan struct{}
+}
+
+func newTTYProgress(out io.Writer, fd uintptr, isTerminal bool) *ttyProgress {
+	pr, pw := io.Pipe()
+	p := &ttyProgress{pw: pw, enc: json.NewEncoder(pw), done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		err := jsonmessage.DisplayJSONMessagesStream(pr, out, fd, isTerminal, nil)
+		if err != nil {
+			log.Debug().Err(err).Msg("Could not render progress")
+		}
+		_ = pr.CloseWithError(io.ErrClosedPipe)
+	}()
+	return p
+}
+
+func (p *ttyProgress) update(msg jsonmessage.JSONMessage) {
+	_ = p.enc.Encode(msg)
+}
+
+func (p *ttyProgress) close() {
+	_ = p.pw.Close()
+	<-p.done
+}
+
+// plainProgress tracks layers and steps to log a summary of their progress
+// at most once per interval.
+type plainProgress struct {
+	title    string
+	interval time.Duration
+	now      func() time.Time
+	last     time.Time
+	order    []string
+	items    map[string]*Progress
+	changed  bool
+}
+
+func newPlainProgress(title string, interval time.Duration) *plainProgress {
+	return &plainProgress{
+		title:    title,
+		interval: interval,
+		now:      time.Now,
+		last:     time.Now(),
+		items:    map[string]*Progress{},
+	}
+}
+
+func (p *plainProgress) update(msg jsonmessage.JSONMessage) {
+	if strings.HasPrefix(msg.Stream, "Step ") {
+		log.Info().Msgf("%s: %s", p.title, strings.TrimSpace(msg.Stream))
+		return
+	}
+	if msg.ID == "" || strings.HasPrefix(msg.Status, "Pulling from ") || strings.HasPrefix(msg.Status, "The push refers to ") {
+		return
+	}
+	item, ok := p.items[msg.ID]
+	if !ok {
+		item = &Progress{ID: msg.ID}
+		p.items[msg.ID] = item
+		p.order = append(p.order, msg.ID)
+	}
+	item.Status = msg.Status
+	if msg.Progress != nil && msg.Progress.Total > 0 {
+		item.Current, item.Total = msg.Progress.Current, msg.Progress.Total
+	}
+	p.changed = true
+
+	if now := p.now(); now.Sub(p.last) >= p.interval {
+		p.last = now
+		p.summarize()
+	}
+}
+
+func (p *plainProgress) close() {
+	if len(p.items) > 0 {
+		p.summarize()
+	}
+}
+
+func (p *plainProgress) summarize() {
+	if !p.changed {
+		return
+	}
+	p.changed = false
+
+	var done int
+	var current, total int64
+	for _, id := range p.order {
+		item := p.items[id]
+		if progressDone(item.Status) {
+			done++
+		}
+		if item.Total > 0 {
+			current += item.Current
+			total += item.Total
+		}
+	}
+	summary := fmt.Sprintf("%s: %d/%d complete", p.title, done, len(p.order))
+	if total > 0 {
+		summary = fmt.Sprintf("%s, %s/%s", summary, units.HumanSize(float64(current)), units.HumanSize(float64(total)))
+	}
+	log.Info().Msg(summary)
+}
+
+// progressDone reports whether a status ends the progress of a layer or
+// build step.
+func progressDone(status string) bool {
+	for _, s := range []string{"complete", "Already exists", "Pushed", "Layer already exists", "DONE", "CACHED", "ERROR"} {
+		if strings.Contains(status, s) {
+			return true
+		}
+	}
+	return false
+}
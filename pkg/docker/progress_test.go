@@ -0,0 +1,131 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ankorstore/ankorstore-cli-modules/pkg/docker/mocks"
+	"github.com/docker/docker/pkg/jsonmessage"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/opencontainers/go-digest"
+	"github.com/phpboyscout/zltest"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const pullStream = `
+	{"status": "Pulling from library/busybox", "id": "latest"}
+	{"status": "Downloading", "id": "a3ed95caeb02", "progressDetail": {"current": 1000000, "total": 3000000}}
+	{"status": "Already exists", "id": "b5c1e0f6d0e7"}
+	{"status": "Downloading", "id": "a3ed95caeb02", "progressDetail": {"current": 3000000, "total": 3000000}}
+	{"status": "Pull complete", "id": "a3ed95caeb02"}
+	{"status": "Status: Downloaded newer image for busybox:latest"}
+`
+
+func TestProgress(t *testing.T) {
+	helper := zltest.New(t)
+	log.Logger = zerolog.New(helper)
+
+	t.Run("summarizes progress once per interval in plain mode", func(t *testing.T) {
+		helper.Reset()
+		clock := time.Date(2022, 7, 1, 10, 0, 0, 0, time.UTC)
+		p := &progress{plain: newPlainProgress("Pulling busybox", 10*time.Second)}
+		p.plain.last = clock
+		p.plain.now = func() time.Time {
+			clock = clock.Add(4 * time.Second)
+			return clock
+		}
+
+		_, err := printOutput(strings.NewReader(pullStream), StatusOutput, p)
+		assert.NoError(t, err)
+		p.close()
+
+		helper.Entries().ExpMsg("Pulling busybox: 1/2 complete, 3MB/3MB")
+		helper.Entries().ExpMsg("Pulling busybox: 2/2 complete, 3MB/3MB")
+		helper.Entries().NotExpMsg("Pulling busybox: 1/2 complete, 1MB/3MB")
+	})
+
+	t.Run("logs build steps in plain mode", func(t *testing.T) {
+		helper.Reset()
+		p := &progress{plain: newPlainProgress("Building ankor:latest", time.Hour)}
+		p.update(jsonmessage.JSONMessage{Stream: "Step 1/2 : FROM busybox\n"})
+		p.close()
+
+		helper.Entries().ExpMsg("Building ankor:latest: Step 1/2 : FROM busybox")
+	})
+
+	t.Run("renders messages in tty mode", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		p := &progress{tty: newTTYProgress(out, 0, false)}
+
+		_, err := printOutput(strings.NewReader(pullStream), StatusOutput, p)
+		assert.NoError(t, err)
+		p.close()
+
+		assert.Contains(t, out.String(), "latest: Pulling from library/busybox\n")
+		assert.Contains(t, out.String(), "a3ed95caeb02: Pull complete\n")
+		assert.Contains(t, out.String(), "Status: Downloaded newer image for busybox:latest\n")
+	})
+
+	t.Run("reports every update to the progress func", func(t *testing.T) {
+		b := &mocks.ImageAPIClient{}
+		b.On("ImagePull", mock.Anything, "busybox", mock.Anything).
+			Once().
+			Return(io.NopCloser(strings.NewReader(pullStream)), nil)
+
+		var updates []Progress
+		d, _ := NewClient(WithImageClient(b), WithContainerClient(&mocks.ContainerAPIClient{}), WithProgressMode(ProgressNone),
+			WithProgressFunc(func(p Progress) { updates = append(updates, p) }))
+		assert.NoError(t, d.PullImage(context.Background(), "busybox"))
+
+		assert.Len(t, updates, 6)
+		assert.Equal(t, Progress{ID: "a3ed95caeb02", Status: "Downloading", Current: 1000000, Total: 3000000}, updates[1])
+		assert.Equal(t, Progress{Status: "Status: Downloaded newer image for busybox:latest"}, updates[5])
+	})
+
+	t.Run("reports buildkit steps", func(t *testing.T) {
+		started := time.Date(2022, 7, 1, 10, 0, 0, 0, time.UTC)
+		completed := started.Add(1500 * time.Millisecond)
+		build := digest.FromString("build")
+		stream := strings.Join([]string{
+			traceMessage(t, &controlapi.StatusResponse{
+				Vertexes: []*controlapi.Vertex{{Digest: build, Name: "[2/2] RUN go build ./...", Started: &started}},
+			}),
+			traceMessage(t, &controlapi.StatusResponse{
+				Vertexes: []*controlapi.Vertex{{Digest: build, Name: "[2/2] RUN go build ./...", Started: &started, Completed: &completed}},
+			}),
+		}, "\n")
+
+		var updates []Progress
+		p := &progress{fn: func(p Progress) { updates = append(updates, p) }}
+		_, err := printBuildKitOutput(strings.NewReader(stream), p)
+		assert.NoError(t, err)
+
+		assert.Equal(t, []Progress{
+			{ID: "#1 [2/2] RUN go build ./...", Status: "running"},
+			{ID: "#1 [2/2] RUN go build ./...", Status: "DONE 1.5s"},
+		}, updates)
+	})
+
+	t.Run("validates options", func(t *testing.T) {
+		_, err := NewClient(WithProgressMode("fancy"))
+		assert.Error(t, err)
+		_, err = NewClient(WithProgressInterval(0))
+		assert.Error(t, err)
+	})
+}
+
+func TestProgressDone(t *testing.T) {
+	for _, status := range []string{"Pull complete", "Already exists", "Pushed", "Layer already exists", "DONE 1.2s", "CACHED"} {
+		assert.True(t, progressDone(status), status)
+	}
+	for _, status := range []string{"Downloading", "Extracting", "Waiting", "running"} {
+		assert.False(t, progressDone(status), status)
+	}
+}
@@ -0,0 +1,139 @@
+package docker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/go-errors/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+const (
+	DockerHubHost      = "docker.io"
+	DockerHubIndexHost = "https://index.docker.io/v1/"
+)
+
+// dockerHubAliases are the keys Docker Hub credentials may be stored under.
+var dockerHubAliases = []string{DockerHubIndexHost, DockerHubHost, "index.docker.io", "registry-1.docker.io"}
+
+// registryHost returns the registry host an image reference points to, e.g.
+// eu.gcr.io for eu.gcr.io/project/image:tag or docker.io for busybox.
+func registryHost(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", errors.Wrap(err, 0)
+	}
+	return reference.Domain(named), nil
+}
+
+func isDockerHub(host string) bool {
+	for _, alias := range dockerHubAliases {
+		if host == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// authConfigFor picks the credentials for a registry host out of auths.
+func authConfigFor(auths map[string]types.AuthConfig, host string) (types.AuthConfig, bool) {
+	if ac, ok := auths[host]; ok {
+		return ac, true
+	}
+	if isDockerHub(host) {
+		for _, alias := range dockerHubAliases {
+			if ac, ok := auths[alias]; ok {
+				return ac, true
+			}
+		}
+	}
+	return types.AuthConfig{}, false
+}
+
+// encodeAuthConfig encodes credentials for the X-Registry-Auth header.
+func encodeAuthConfig(ac types.AuthConfig) (string, error) {
+	encodedJSON, err := json.Marshal(ac)
+	if err != nil {
+		return "", errors.Wrap(err, 0)
+	}
+	return base64.URLEncoding.EncodeToString(encodedJSON), nil
+}
+
+// registryAuth returns the encoded credentials to send to the registry of
+// ref, which are empty when no helper serves it.
+func (c *Client) registryAuth(ref string) (string, error) {
+	ac, err := c.authConfigForRef(ref)
+	if err != nil {
+		return "", err
+	}
+	return encodeAuthConfig(ac)
+}
+
+// authConfigForRef resolves the credentials of the registry ref points to
+// with the same precedence as GetAuthConfig, but only invokes the helper
+// serving that registry so no other credential is fetched or sent.
+func (c *Client) authConfigForRef(ref string) (types.AuthConfig, error) {
+	host, err := registryHost(ref)
+	if err != nil {
+		return types.AuthConfig{}, err
+	}
+
+	regs, err := registries()
+	if err != nil {
+		return types.AuthConfig{}, err
+	}
+	for i := len(regs) - 1; i >= 0; i-- {
+		if !sameRegistry(regs[i].Host, host) {
+			continue
+		}
+		helper, err := c.newAuthHelper(regs[i])
+		if err != nil {
+			return types.AuthConfig{}, errors.WrapPrefix(err, regs[i].Host, 0)
+		}
+		log.Debug().Msgf("Using '%s' credentials for %s", regs[i].Helper, host)
+		return helper.AuthConfig(regs[i].Host)
+	}
+
+	names := viper.GetStringSlice("docker.authentication")
+	for i := len(names) - 1; i >= 0; i-- {
+		helper, err := c.newAuthHelper(Registry{Helper: names[i]})
+		if err != nil {
+			return types.AuthConfig{}, err
+		}
+		if servesHost(helper, host) {
+			log.Debug().Msgf("Using '%s' credentials for %s", names[i], host)
+			return helper.AuthConfig(host)
+		}
+	}
+
+	helper, err := c.newAuthHelper(Registry{Helper: DockerConfig})
+	if err != nil {
+		log.Warn().Err(err).Msg("Ignoring docker CLI credentials")
+		return types.AuthConfig{}, nil
+	}
+	ac, err := helper.AuthConfig(host)
+	if err != nil {
+		log.Warn().Err(err).Str("registry", host).Msg("Ignoring docker CLI credentials")
+		return types.AuthConfig{}, nil
+	}
+	return ac, nil
+}
+
+func sameRegistry(a, b string) bool {
+	return a == b || (isDockerHub(a) && isDockerHub(b))
+}
+
+// servesHost reports whether an enabled helper provides the credentials of
+// host.
+func servesHost(helper AuthHelper, host string) bool {
+	for _, h := range helper.Hosts() {
+		if sameRegistry(h, host) {
+			return true
+		}
+	}
+	matcher, ok := helper.(HostMatcher)
+	return ok && matcher.Matches(host)
+}
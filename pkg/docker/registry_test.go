@@ -0,0 +1,140 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ankorstore/ankorstore-cli-modules/pkg/docker/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func decodeAuth(t *testing.T, s string) types.AuthConfig {
+	b, err := base64.URLEncoding.DecodeString(s)
+	assert.NoError(t, err)
+	var ac types.AuthConfig
+	assert.NoError(t, json.Unmarshal(b, &ac))
+	return ac
+}
+
+func useGcloudConfig(t *testing.T) *mocks.Authenticator {
+	useConfig(t, `{"docker": { "authentication" : ["gcloud"]}}`)
+
+	authMock := &mocks.Authenticator{}
+	authMock.On("Authorization").
+		Return(&authn.AuthConfig{Username: "_token", Password: "ya29.encryptedtoken"}, nil)
+	return authMock
+}
+
+func TestRegistryHost(t *testing.T) {
+	cases := map[string]string{
+		"busybox":                                    "docker.io",
+		"library/busybox:latest":                     "docker.io",
+		"eu.gcr.io/ankorstore/ankor:v1":              "eu.gcr.io",
+		"europe-west1-docker.pkg.dev/project/repo/x": "europe-west1-docker.pkg.dev",
+		"localhost:5000/ankor":                       "localhost:5000",
+	}
+	for ref, expected := range cases {
+		host, err := registryHost(ref)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, host, ref)
+	}
+
+	_, err := registryHost("UPPERCASE/Not-Valid")
+	assert.Error(t, err)
+}
+
+func TestAuthConfigFor(t *testing.T) {
+	auths := map[string]types.AuthConfig{
+		"eu.gcr.io":        {Username: "gcloud"},
+		DockerHubIndexHost: {Username: "hub"},
+	}
+
+	ac, ok := authConfigFor(auths, "eu.gcr.io")
+	assert.True(t, ok)
+	assert.Equal(t, "gcloud", ac.Username)
+
+	ac, ok = authConfigFor(auths, "docker.io")
+	assert.True(t, ok)
+	assert.Equal(t, "hub", ac.Username)
+
+	_, ok = authConfigFor(auths, "ghcr.io")
+	assert.False(t, ok)
+}
+
+func TestPushImage(t *testing.T) {
+	authMock := useGcloudConfig(t)
+
+	t.Run("sends the credentials of the image registry", func(t *testing.T) {
+		b := &mocks.ImageAPIClient{}
+		b.On("ImagePush", mock.Anything, "eu.gcr.io/ankorstore/ankor:v1", mock.MatchedBy(func(opts types.ImagePushOptions) bool {
+			return decodeAuth(t, opts.RegistryAuth).Password == "ya29.encryptedtoken"
+		})).
+			Once().
+			Return(io.NopCloser(strings.NewReader(`{"status": "Pushed", "id": "abc123"}`)), nil)
+
+		d, _ := NewClient(WithImageClient(b), WithContainerClient(&mocks.ContainerAPIClient{}), WithAuthenticator(authMock))
+		err := d.PushImage(context.Background(), "eu.gcr.io/ankorstore/ankor:v1")
+		assert.NoError(t, err)
+		b.AssertExpectations(t)
+	})
+
+	t.Run("sends no credentials to other registries", func(t *testing.T) {
+		b := &mocks.ImageAPIClient{}
+		b.On("ImagePush", mock.Anything, "ankorstore/ankor:latest", mock.MatchedBy(func(opts types.ImagePushOptions) bool {
+			return decodeAuth(t, opts.RegistryAuth) == types.AuthConfig{}
+		})).
+			Once().
+			Return(io.NopCloser(strings.NewReader(`{"status": "Pushed"}`)), nil)
+
+		d, _ := NewClient(WithImageClient(b), WithContainerClient(&mocks.ContainerAPIClient{}), WithAuthenticator(authMock))
+		err := d.PushImage(context.Background(), "ankorstore/ankor")
+		assert.NoError(t, err)
+		b.AssertExpectations(t)
+	})
+
+	t.Run("returns errors from the push stream", func(t *testing.T) {
+		b := &mocks.ImageAPIClient{}
+		b.On("ImagePush", mock.Anything, mock.Anything, mock.Anything).
+			Once().
+			Return(io.NopCloser(strings.NewReader(`{"status": "Preparing", "id": "abc123"}
+{"errorDetail": {"message": "denied: requested access to the resource is denied"}, "error": "denied: requested access to the resource is denied"}`)), nil)
+
+		d, _ := NewClient(WithImageClient(b), WithContainerClient(&mocks.ContainerAPIClient{}), WithAuthenticator(authMock))
+		err := d.PushImage(context.Background(), "eu.gcr.io/ankorstore/ankor:v1")
+		assert.ErrorContains(t, err, "denied")
+	})
+
+	t.Run("pushes all tags of a build", func(t *testing.T) {
+		windows := &mocks.SystemAPIClient{}
+		windows.On("Ping", mock.Anything).Return(types.Ping{APIVersion: "1.41", OSType: "windows"}, nil)
+		b := &mocks.ImageAPIClient{}
+		b.On("ImageBuild", mock.Anything, mock.Anything, mock.Anything).
+			Once().
+			Return(types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(`{"stream": "done"}`))}, nil)
+		for _, tag := range []string{"eu.gcr.io/ankorstore/ankor:latest", "eu.gcr.io/ankorstore/ankor:abc123"} {
+			b.On("ImagePush", mock.Anything, tag, mock.Anything).
+				Once().
+				Return(io.NopCloser(strings.NewReader(`{"status": "Pushed"}`)), nil)
+		}
+
+		d, _ := NewClient(WithImageClient(buildContextReader{b}), WithContainerClient(&mocks.ContainerAPIClient{}), WithSystemClient(windows),
+			WithHijackDialer(&mocks.HijackDialer{}), WithAuthenticator(authMock))
+		_, err := d.BuildImage(context.Background(), "./testdata",
+			BuildWithTags("eu.gcr.io/ankorstore/ankor:latest", "eu.gcr.io/ankorstore/ankor:abc123"),
+			BuildWithPush())
+		assert.NoError(t, err)
+		b.AssertExpectations(t)
+	})
+
+	t.Run("requires tags to push a build", func(t *testing.T) {
+		_, err := newBuildConfig(BuildWithPush())
+		assert.ErrorIs(t, err, ErrMissingOption)
+	})
+}
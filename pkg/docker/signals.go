@@ -0,0 +1,19 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// cleanupTimeout bounds the calls cleaning up after an operation whose
+// context is done.
+const cleanupTimeout = 10 * time.Second
+
+// notifyContext returns a copy of ctx that is also done once the process
+// receives SIGINT or SIGTERM, until stop is called.
+var notifyContext = func(ctx context.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+
+package docker
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRunSignals(t *testing.T) {
+	// record whether Run releases its signal handler
+	var released int32
+	notify := notifyContext
+	notifyContext = func(ctx context.Context) (context.Context, context.CancelFunc) {
+		ctx, stop := notify(ctx)
+		return ctx, func() {
+			stop()
+			atomic.StoreInt32(&released, 1)
+		}
+	}
+	defer func() { notifyContext = notify }()
+
+	r, w := io.Pipe()
+	defer func() { _ = w.Close() }()
+	c := runMock(r, make(chan container.ContainerWaitOKBody), make(chan error))
+	timeout := DefaultStopTimeout
+	c.On("ContainerStop", mock.Anything, "abc123", &timeout).Once().Return(nil)
+	c.On("ContainerRemove", mock.Anything, "abc123", types.ContainerRemoveOptions{Force: true, RemoveVolumes: true}).
+		Once().
+		Return(nil)
+
+	// the container keeps running until the process is interrupted once it
+	// has written its first output
+	stdout := &signalWriter{written: make(chan struct{}, 1)}
+	go func() {
+		_, _ = stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte("still running\n"))
+		<-stdout.written
+		_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	}()
+
+	d, _ := NewClient(WithContainerClient(c), WithImageClient(presentImages()))
+	err := d.Run(context.Background(), RunWithImage("busybox"), RunWithOutput(stdout, io.Discard))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "container ankor_test interrupted")
+	c.AssertExpectations(t)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&released), "the signal handler is released")
+}
@@ -0,0 +1,421 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"github.com/go-errors/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultStackTimeout bounds how long StackUp waits for each service to
+	// be healthy.
+	DefaultStackTimeout = 2 * time.Minute
+	// stackStopTimeout is how long StackDown lets a service stop before
+	// killing it.
+	stackStopTimeout = 10 * time.Second
+
+	// ServiceMissing is the state of a service without a container.
+	ServiceMissing = "missing"
+)
+
+var (
+	ErrUnknownStack      = errors.New("is not a stack declared under docker.stacks")
+	ErrUnknownDependency = errors.New("depends on an unknown service")
+	ErrDependencyCycle   = errors.New("is part of a dependency cycle")
+)
+
+// Stack is a set of services run together on a dedicated network, as
+// declared under docker.stacks.<name> in ankor.yaml.
+type Stack struct {
+	Name string `mapstructure:"-"`
+	// Services are keyed by name, lowercased when loaded by viper.
+	Services map[string]Service `mapstructure:"services"`
+	// Timeout bounds how long each service may take to become healthy.
+	// Defaults to DefaultStackTimeout.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Service is a container of a stack, reachable by the other services under
+// its name.
+type Service struct {
+	Image   string   `mapstructure:"image"`
+	Command []string `mapstructure:"command"`
+	// Env lists variables as KEY=VALUE, keeping the case viper loses on maps.
+	Env       []string `mapstructure:"env"`
+	EnvFile   string   `mapstructure:"envFile"`
+	Ports     []string `mapstructure:"ports"`
+	DependsOn []string `mapstructure:"dependsOn"`
+	// PullPolicy defaults to PullIfNotPresent.
+	PullPolicy  PullPolicy          `mapstructure:"pullPolicy"`
+	HealthCheck *ServiceHealthCheck `mapstructure:"healthcheck"`
+}
+
+// ServiceHealthCheck decides when a service is ready for its dependents,
+// either with a HEALTHCHECK command, an open port or a log line. Port and Log
+// cannot be combined, and Port must be published under the service ports.
+type ServiceHealthCheck struct {
+	Test        []string      `mapstructure:"test"`
+	Interval    time.Duration `mapstructure:"interval"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+	StartPeriod time.Duration `mapstructure:"startPeriod"`
+	Retries     int           `mapstructure:"retries"`
+	Port        string        `mapstructure:"port"`
+	Log         string        `mapstructure:"log"`
+}
+
+// ServiceStatus reports the container of a stack service.
+type ServiceStatus struct {
+	Service     string
+	ContainerID string
+	Container   string
+	// State is the container state, e.g. "running" or "exited", or
+	// ServiceMissing.
+	State string
+	// Health is the HEALTHCHECK status, empty when the container has none.
+	Health string
+}
+
+// LoadStack reads the stack declared under docker.stacks.<name>.
+func LoadStack(name string) (*Stack, error) {
+	key := "docker.stacks." + name
+	if !viper.IsSet(key) {
+		return nil, errors.New(fmt.Errorf("'%s' %w", name, ErrUnknownStack))
+	}
+	stack := &Stack{}
+	if err := viper.UnmarshalKey(key, stack); err != nil {
+		return nil, errors.WrapPrefix(err, fmt.Sprintf("invalid %s configuration", key), 0)
+	}
+	stack.Name = name
+	// viper lowercases the service names but not the dependencies
+	for _, svc := range stack.Services {
+		for i, dep := range svc.DependsOn {
+			svc.DependsOn[i] = strings.ToLower(dep)
+		}
+	}
+	if _, err := stack.order(); err != nil {
+		return nil, err
+	}
+	return stack, nil
+}
+
+// order returns the services so that each one comes after its
+// dependencies, breaking ties by name.
+func (s *Stack) order() ([]string, error) {
+	names := make([]string, 0, len(s.Services))
+	for name, svc := range s.Services {
+		if svc.Image == "" {
+			return nil, errors.New(fmt.Errorf("'image' %w for service '%s'", ErrMissingOption, name))
+		}
+		if hc := svc.HealthCheck; hc != nil && hc.Port != "" && hc.Log != "" {
+			return nil, errors.New(fmt.Errorf("'port' %w 'log' in the healthcheck of service '%s'", ErrConflict, name))
+		}
+		if hc := svc.HealthCheck; hc != nil && hc.Port != "" {
+			_, bindings, err := nat.ParsePortSpecs(svc.Ports)
+			if err != nil {
+				return nil, errors.WrapPrefix(err, fmt.Sprintf("invalid ports of service '%s'", name), 0)
+			}
+			if len(bindings[tcpPort(hc.Port)]) == 0 {
+				return nil, errors.New(fmt.Errorf("'ports' %w to probe port '%s' of service '%s'", ErrMissingOption, hc.Port, name))
+			}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	var ordered []string
+	state := map[string]int{}
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return errors.New(fmt.Errorf("'%s' %w", name, ErrDependencyCycle))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		deps := append([]string{}, s.Services[name].DependsOn...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if _, ok := s.Services[dep]; !ok {
+				return errors.New(fmt.Errorf("'%s' %w '%s'", name, ErrUnknownDependency, dep))
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		ordered = append(ordered, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+func (s *Stack) network() string {
+	return "ankor_" + s.Name
+}
+
+func (s *Stack) containerName(service string) string {
+	return fmt.Sprintf("ankor_%s_%s", s.Name, service)
+}
+
+func (s *Stack) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return DefaultStackTimeout
+	}
+	return s.Timeout
+}
+
+// runOpts translates a service into the options of its detached run.
+func (s *Stack) runOpts(name string) ([]RunOpt, error) {
+	svc := s.Services[name]
+	opts := []RunOpt{
+		RunWithImage(svc.Image),
+		RunWithName(s.containerName(name)),
+		RunWithNetwork(s.network(), name),
+		RunWithLabels(map[string]string{LabelStack: s.Name, LabelService: name}),
+	}
+	if svc.PullPolicy != "" {
+		opts = append(opts, RunWithPullPolicy(svc.PullPolicy))
+	}
+	if len(svc.Command) > 0 {
+		opts = append(opts, RunWithCommand(svc.Command))
+	}
+	if svc.EnvFile != "" {
+		opts = append(opts, RunWithEnvFile(svc.EnvFile))
+	}
+	if len(svc.Env) > 0 {
+		env := map[string]string{}
+		for _, e := range svc.Env {
+			k, v, _ := strings.Cut(e, "=")
+			env[k] = v
+		}
+		opts = append(opts, RunWithEnv(env))
+	}
+	if len(svc.Ports) > 0 {
+		opts = append(opts, RunWithPorts(svc.Ports...))
+	}
+	if hc := svc.HealthCheck; hc != nil {
+		if len(hc.Test) > 0 {
+			opts = append(opts, RunWithHealthCheck(&container.HealthConfig{
+				Test:        hc.Test,
+				Interval:    hc.Interval,
+				Timeout:     hc.Timeout,
+				StartPeriod: hc.StartPeriod,
+				Retries:     hc.Retries,
+			}))
+		}
+		if hc.Port != "" {
+			opts = append(opts, RunWithHealthProbe(TCPProbe(hc.Port)))
+		}
+		if hc.Log != "" {
+			pattern, err := regexp.Compile(hc.Log)
+			if err != nil {
+				return nil, errors.WrapPrefix(err, fmt.Sprintf("invalid healthcheck log of service '%s'", name), 0)
+			}
+			opts = append(opts, RunWithHealthProbe(LogProbe(pattern)))
+		}
+	}
+	return opts, nil
+}
+
+// StackUp creates the network of the stack and starts its services in
+// dependency order, waiting for each one to be healthy before starting the
+// next. Services that already run are kept, stopped ones are recreated.
+func (c *Client) StackUp(ctx context.Context, stack *Stack) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+	order, err := stack.order()
+	if err != nil {
+		return err
+	}
+	if err := c.ensureStackNetwork(ctx, stack); err != nil {
+		return err
+	}
+
+	for _, name := range order {
+		opts, err := stack.runOpts(name)
+		if err != nil {
+			return err
+		}
+		ct, err := c.stackContainer(ctx, stack, name, opts)
+		if err != nil {
+			return errors.WrapPrefix(err, fmt.Sprintf("starting service '%s'", name), 0)
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, stack.timeout())
+		err = ct.WaitHealthy(waitCtx)
+		cancel()
+		if err != nil {
+			return errors.WrapPrefix(err, fmt.Sprintf("starting service '%s'", name), 0)
+		}
+		log.Info().Str("stack", stack.Name).Msgf("Service %s is ready", name)
+	}
+	return nil
+}
+
+func (c *Client) ensureStackNetwork(ctx context.Context, stack *Stack) error {
+	_, err := c.inspectNetwork(ctx, stack.network())
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	_, err = c.createNetwork(ctx, stack.network(), map[string]string{LabelStack: stack.Name})
+	return err
+}
+
+// stackContainer returns the running container of a service, replacing any
+// container of the stack left over from a previous run. A container of the
+// same name outside the stack is never removed.
+func (c *Client) stackContainer(ctx context.Context, stack *Stack, name string, opts []RunOpt) (*Container, error) {
+	runConfig, err := newRunConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	containerName := stack.containerName(name)
+	info, err := c.containers.ContainerInspect(ctx, containerName)
+	switch {
+	case client.IsErrNotFound(err):
+	case err != nil:
+		return nil, errors.Wrap(err, 0)
+	case info.Config == nil || info.Config.Labels[LabelStack] != stack.Name:
+		// the name is taken by a container this stack does not own
+		return nil, errors.New(fmt.Errorf("service '%s' %w container '%s' outside the stack", name, ErrConflict, containerName))
+	case info.State != nil && info.State.Running:
+		log.Debug().Msgf("Service %s is already running in container %s", name, containerName)
+		return &Container{
+			ID:           info.ID,
+			Name:         containerName,
+			client:       c,
+			tty:          info.Config.Tty,
+			probe:        runConfig.HealthProbe,
+			exitLogLines: runConfig.exitLogLines(),
+		}, nil
+	default:
+		log.Debug().Msgf("Removing stale container %s of service %s", containerName, name)
+		if err := (&Container{ID: info.ID, client: c}).Remove(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return c.RunDetached(ctx, opts...)
+}
+
+// StackDown stops and removes the containers of the stack in reverse
+// dependency order, then its network.
+func (c *Client) StackDown(ctx context.Context, stack *Stack) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+	order, err := stack.order()
+	if err != nil {
+		return err
+	}
+	containers, err := c.stackContainers(ctx, stack)
+	if err != nil {
+		return err
+	}
+
+	// services removed from the configuration go first, then dependents
+	// before their dependencies
+	rank := map[string]int{}
+	for i, name := range order {
+		rank[name] = len(order) - i
+	}
+	sort.SliceStable(containers, func(i, j int) bool {
+		return rank[containers[i].Labels[LabelService]] < rank[containers[j].Labels[LabelService]]
+	})
+
+	for _, info := range containers {
+		ct := &Container{ID: info.ID, Name: containerName(info), client: c}
+		log.Debug().Msgf("Removing container %s of service %s", ct, info.Labels[LabelService])
+		if info.State == "running" {
+			// removal kills the container anyway if it cannot be stopped
+			if err := ct.Stop(ctx, stackStopTimeout); err != nil {
+				log.Debug().Err(err).Msgf("Could not stop container %s", ct)
+			}
+		}
+		if err := ct.Remove(ctx); err != nil {
+			return err
+		}
+	}
+
+	return c.removeNetwork(ctx, stack.network())
+}
+
+// StackStatus reports the container of every service of the stack, in
+// dependency order.
+func (c *Client) StackStatus(ctx context.Context, stack *Stack) ([]ServiceStatus, error) {
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	order, err := stack.order()
+	if err != nil {
+		return nil, err
+	}
+	containers, err := c.stackContainers(ctx, stack)
+	if err != nil {
+		return nil, err
+	}
+	byService := map[string]types.Container{}
+	for _, info := range containers {
+		byService[info.Labels[LabelService]] = info
+	}
+
+	statuses := make([]ServiceStatus, 0, len(order))
+	for _, name := range order {
+		status := ServiceStatus{Service: name, State: ServiceMissing}
+		if info, ok := byService[name]; ok {
+			status.ContainerID = info.ID
+			status.Container = containerName(info)
+			status.State = info.State
+			if details, err := c.containers.ContainerInspect(ctx, info.ID); err == nil {
+				if details.State != nil && details.State.Health != nil {
+					status.Health = details.State.Health.Status
+				}
+			} else {
+				log.Debug().Err(err).Msgf("Could not inspect container %s", info.ID)
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (c *Client) stackContainers(ctx context.Context, stack *Stack) ([]types.Container, error) {
+	containers, err := c.containers.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelStack+"="+stack.Name)),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+	return containers, nil
+}
+
+func containerName(info types.Container) string {
+	if len(info.Names) == 0 {
+		return info.ID
+	}
+	return strings.TrimPrefix(info.Names[0], "/")
+}
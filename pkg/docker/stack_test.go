@@ -0,0 +1,318 @@
+package docker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ankorstore/ankorstore-cli-modules/pkg/docker/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/go-errors/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const stackConfig = `{
+	"docker": {"stacks": {"backend": {
+		"timeout": "30s",
+		"services": {
+			"app": {"image": "app:latest", "command": ["serve"], "dependsOn": ["db", "cache"], "ports": ["8080:80"]},
+			"db": {
+				"image": "postgres:14",
+				"env": ["POSTGRES_PASSWORD=secret"],
+				"healthcheck": {"test": ["CMD", "pg_isready"], "interval": "2s", "retries": 5}
+			},
+			"cache": {"image": "redis:7", "pullPolicy": "always", "ports": ["127.0.0.1::6379"], "healthcheck": {"port": "6379"}}
+		}
+	}}}
+}`
+
+func testStack() *Stack {
+	return &Stack{
+		Name: "backend",
+		Services: map[string]Service{
+			"db":  {Image: "postgres:14", HealthCheck: &ServiceHealthCheck{Test: []string{"CMD", "pg_isready"}}},
+			"app": {Image: "app:latest", DependsOn: []string{"db"}},
+		},
+	}
+}
+
+func TestLoadStack(t *testing.T) {
+	t.Run("reads services from the configuration", func(t *testing.T) {
+		useConfig(t, stackConfig)
+
+		stack, err := LoadStack("backend")
+		assert.NoError(t, err)
+		assert.Equal(t, "backend", stack.Name)
+		assert.Equal(t, 30*time.Second, stack.timeout())
+		assert.Equal(t, []string{"POSTGRES_PASSWORD=secret"}, stack.Services["db"].Env)
+		assert.Equal(t, &ServiceHealthCheck{Test: []string{"CMD", "pg_isready"}, Interval: 2 * time.Second, Retries: 5}, stack.Services["db"].HealthCheck)
+		assert.Equal(t, PullAlways, stack.Services["cache"].PullPolicy)
+
+		order, err := stack.order()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"cache", "db", "app"}, order)
+	})
+
+	t.Run("matches dependencies on mixed case service names", func(t *testing.T) {
+		useConfig(t, `{"docker": {"stacks": {"backend": {"services": {
+			"api": {"image": "api:latest", "dependsOn": ["mainDB"]},
+			"mainDB": {"image": "postgres:14"}
+		}}}}}`)
+
+		stack, err := LoadStack("backend")
+		assert.NoError(t, err)
+		order, err := stack.order()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"maindb", "api"}, order)
+	})
+
+	t.Run("with an unknown stack", func(t *testing.T) {
+		useConfig(t, stackConfig)
+
+		_, err := LoadStack("frontend")
+		assert.ErrorIs(t, err, ErrUnknownStack)
+	})
+
+	cases := []struct {
+		label    string
+		services map[string]Service
+		expected error
+		message  string
+	}{
+		{
+			label:    "with an unknown dependency",
+			services: map[string]Service{"app": {Image: "app", DependsOn: []string{"db"}}},
+			expected: ErrUnknownDependency,
+			message:  "'app' depends on an unknown service 'db'",
+		},
+		{
+			label: "with a dependency cycle",
+			services: map[string]Service{
+				"a": {Image: "a", DependsOn: []string{"b"}},
+				"b": {Image: "b", DependsOn: []string{"a"}},
+			},
+			expected: ErrDependencyCycle,
+			message:  "'a' is part of a dependency cycle",
+		},
+		{
+			label:    "without an image",
+			services: map[string]Service{"app": {}},
+			expected: ErrMissingOption,
+			message:  "'image' must be declared for service 'app'",
+		},
+		{
+			label: "with a port and a log healthcheck",
+			services: map[string]Service{
+				"db": {Image: "postgres:14", HealthCheck: &ServiceHealthCheck{Port: "5432", Log: "ready"}},
+			},
+			expected: ErrConflict,
+			message:  "'port' cannot be combined with 'log' in the healthcheck of service 'db'",
+		},
+		{
+			label: "with an unpublished healthcheck port",
+			services: map[string]Service{
+				"cache": {Image: "redis:7", Ports: []string{"6380:6380"}, HealthCheck: &ServiceHealthCheck{Port: "6379"}},
+			},
+			expected: ErrMissingOption,
+			message:  "'ports' must be declared to probe port '6379' of service 'cache'",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.label, func(t *testing.T) {
+			_, err := (&Stack{Name: "backend", Services: c.services}).order()
+			assert.ErrorIs(t, err, c.expected)
+			assert.Equal(t, c.message, err.Error())
+		})
+	}
+}
+
+func TestStackRunOpts(t *testing.T) {
+	useConfig(t, stackConfig)
+	stack, _ := LoadStack("backend")
+
+	opts, err := stack.runOpts("db")
+	assert.NoError(t, err)
+	cfg, err := newRunConfig(opts...)
+	assert.NoError(t, err)
+	assert.Equal(t, "ankor_backend_db", cfg.Name)
+	assert.Equal(t, container.NetworkMode("ankor_backend"), cfg.HostConfig.NetworkMode)
+	assert.Equal(t, []string{"db"}, cfg.NetworkConfig.EndpointsConfig["ankor_backend"].Aliases)
+	assert.Equal(t, []string{"POSTGRES_PASSWORD=secret"}, cfg.Config.Env)
+	assert.Equal(t, map[string]string{LabelStack: "backend", LabelService: "db"}, cfg.Config.Labels)
+	assert.Equal(t, []string{"CMD", "pg_isready"}, cfg.Config.Healthcheck.Test)
+	assert.Equal(t, PullIfNotPresent, cfg.pullPolicy())
+	assert.Nil(t, cfg.HealthProbe)
+
+	opts, _ = stack.runOpts("cache")
+	cfg, _ = newRunConfig(opts...)
+	assert.Equal(t, TCPProbe("6379"), cfg.HealthProbe)
+	assert.Equal(t, PullAlways, cfg.pullPolicy())
+}
+
+func TestStackUp(t *testing.T) {
+	healthPollInterval = time.Millisecond
+	healthy := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+		State: &types.ContainerState{Running: true, Health: &types.Health{Status: types.Healthy}},
+	}}
+
+	t.Run("starts services in dependency order on the stack network", func(t *testing.T) {
+		n := &mocks.NetworkAPIClient{}
+		n.On("NetworkInspect", mock.Anything, "ankor_backend", mock.Anything).
+			Once().
+			Return(types.NetworkResource{}, errdefs.NotFound(errors.New("no such network")))
+		n.On("NetworkCreate", mock.Anything, "ankor_backend", mock.MatchedBy(func(opts types.NetworkCreate) bool {
+			return opts.Labels[LabelStack] == "backend" && opts.Labels[LabelManaged] == "true"
+		})).
+			Once().
+			Return(types.NetworkCreateResponse{ID: "net"}, nil)
+
+		i := &mocks.ImageAPIClient{}
+		i.On("ImageInspectWithRaw", mock.Anything, mock.Anything).Return(types.ImageInspect{}, nil, nil)
+
+		c := &mocks.ContainerAPIClient{}
+		var created []string
+		for _, name := range []string{"db", "app"} {
+			containerName := "ankor_backend_" + name
+			c.On("ContainerInspect", mock.Anything, containerName).
+				Once().
+				Return(types.ContainerJSON{}, errdefs.NotFound(errors.New("no such container")))
+			c.On("ContainerCreate", mock.Anything, mock.Anything, mock.Anything, mock.MatchedBy(func(cfg *network.NetworkingConfig) bool {
+				return cfg.EndpointsConfig["ankor_backend"] != nil
+			}), mock.Anything, containerName).
+				Once().
+				Run(func(args mock.Arguments) { created = append(created, args.String(5)) }).
+				Return(container.ContainerCreateCreatedBody{ID: name + "-id"}, nil)
+			c.On("ContainerStart", mock.Anything, name+"-id", mock.Anything).Once().Return(nil)
+			c.On("ContainerInspect", mock.Anything, name+"-id").Once().Return(healthy, nil)
+		}
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(i), WithNetworkClient(n))
+		assert.NoError(t, d.StackUp(context.Background(), testStack()))
+		assert.Equal(t, []string{"ankor_backend_db", "ankor_backend_app"}, created)
+		c.AssertExpectations(t)
+		n.AssertExpectations(t)
+	})
+
+	t.Run("keeps running services and recreates stopped ones", func(t *testing.T) {
+		n := &mocks.NetworkAPIClient{}
+		n.On("NetworkInspect", mock.Anything, "ankor_backend", mock.Anything).Once().Return(types.NetworkResource{}, nil)
+
+		i := &mocks.ImageAPIClient{}
+		i.On("ImageInspectWithRaw", mock.Anything, mock.Anything).Return(types.ImageInspect{}, nil, nil)
+
+		c := &mocks.ContainerAPIClient{}
+		c.On("ContainerInspect", mock.Anything, "ankor_backend_db").Once().Return(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{ID: "db-id", State: &types.ContainerState{Running: true}},
+			Config:            &container.Config{Labels: map[string]string{LabelStack: "backend"}},
+		}, nil)
+		c.On("ContainerInspect", mock.Anything, "db-id").Once().Return(healthy, nil)
+		c.On("ContainerInspect", mock.Anything, "ankor_backend_app").Once().Return(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{ID: "old-app-id", State: &types.ContainerState{Status: "exited"}},
+			Config:            &container.Config{Labels: map[string]string{LabelStack: "backend"}},
+		}, nil)
+		c.On("ContainerRemove", mock.Anything, "old-app-id", mock.Anything).Once().Return(nil)
+		c.On("ContainerCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, "ankor_backend_app").
+			Once().
+			Return(container.ContainerCreateCreatedBody{ID: "app-id"}, nil)
+		c.On("ContainerStart", mock.Anything, "app-id", mock.Anything).Once().Return(nil)
+		c.On("ContainerInspect", mock.Anything, "app-id").Once().Return(healthy, nil)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(i), WithNetworkClient(n))
+		assert.NoError(t, d.StackUp(context.Background(), testStack()))
+		c.AssertExpectations(t)
+	})
+
+	t.Run("leaves containers outside the stack alone", func(t *testing.T) {
+		n := &mocks.NetworkAPIClient{}
+		n.On("NetworkInspect", mock.Anything, "ankor_backend", mock.Anything).Once().Return(types.NetworkResource{}, nil)
+
+		i := &mocks.ImageAPIClient{}
+		i.On("ImageInspectWithRaw", mock.Anything, mock.Anything).Return(types.ImageInspect{}, nil, nil)
+
+		c := &mocks.ContainerAPIClient{}
+		c.On("ContainerInspect", mock.Anything, "ankor_backend_db").Once().Return(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{ID: "other-id", State: &types.ContainerState{Running: true}},
+			Config:            &container.Config{Labels: map[string]string{LabelStack: "frontend"}},
+		}, nil)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(i), WithNetworkClient(n))
+		err := d.StackUp(context.Background(), testStack())
+		assert.ErrorIs(t, err, ErrConflict)
+		assert.ErrorContains(t, err, "service 'db' cannot be combined with container 'ankor_backend_db' outside the stack")
+		c.AssertExpectations(t)
+		c.AssertNotCalled(t, "ContainerRemove", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("fails when a service is unhealthy", func(t *testing.T) {
+		n := &mocks.NetworkAPIClient{}
+		n.On("NetworkInspect", mock.Anything, "ankor_backend", mock.Anything).Once().Return(types.NetworkResource{}, nil)
+
+		i := &mocks.ImageAPIClient{}
+		i.On("ImageInspectWithRaw", mock.Anything, mock.Anything).Return(types.ImageInspect{}, nil, nil)
+
+		c := &mocks.ContainerAPIClient{}
+		c.On("ContainerInspect", mock.Anything, "ankor_backend_db").
+			Once().
+			Return(types.ContainerJSON{}, errdefs.NotFound(errors.New("no such container")))
+		c.On("ContainerCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, "ankor_backend_db").
+			Once().
+			Return(container.ContainerCreateCreatedBody{ID: "db-id"}, nil)
+		c.On("ContainerStart", mock.Anything, "db-id", mock.Anything).Once().Return(nil)
+		c.On("ContainerInspect", mock.Anything, "db-id").Once().Return(types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+			State: &types.ContainerState{Running: true, Health: &types.Health{Status: types.Unhealthy}},
+		}}, nil)
+
+		d, _ := NewClient(WithContainerClient(c), WithImageClient(i), WithNetworkClient(n))
+		err := d.StackUp(context.Background(), testStack())
+		assert.ErrorContains(t, err, "starting service 'db'")
+		c.AssertExpectations(t)
+	})
+}
+
+func TestStackDown(t *testing.T) {
+	c := &mocks.ContainerAPIClient{}
+	c.On("ContainerList", mock.Anything, mock.MatchedBy(func(opts types.ContainerListOptions) bool {
+		return opts.All && opts.Filters.ExactMatch("label", LabelStack+"=backend")
+	})).Once().Return([]types.Container{
+		{ID: "db-id", Names: []string{"/ankor_backend_db"}, State: "running", Labels: map[string]string{LabelService: "db"}},
+		{ID: "app-id", Names: []string{"/ankor_backend_app"}, State: "exited", Labels: map[string]string{LabelService: "app"}},
+		{ID: "old-id", Names: []string{"/ankor_backend_old"}, State: "running", Labels: map[string]string{LabelService: "old"}},
+	}, nil)
+
+	var removed []string
+	c.On("ContainerStop", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	c.On("ContainerRemove", mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { removed = append(removed, args.String(1)) }).
+		Return(nil)
+
+	n := &mocks.NetworkAPIClient{}
+	n.On("NetworkRemove", mock.Anything, "ankor_backend").Once().Return(nil)
+
+	d, _ := NewClient(WithContainerClient(c), WithImageClient(&mocks.ImageAPIClient{}), WithNetworkClient(n))
+	assert.NoError(t, d.StackDown(context.Background(), testStack()))
+	assert.Equal(t, []string{"old-id", "app-id", "db-id"}, removed)
+	c.AssertNumberOfCalls(t, "ContainerStop", 2)
+	n.AssertExpectations(t)
+}
+
+func TestStackStatus(t *testing.T) {
+	c := &mocks.ContainerAPIClient{}
+	c.On("ContainerList", mock.Anything, mock.Anything).Once().Return([]types.Container{
+		{ID: "db-id", Names: []string{"/ankor_backend_db"}, State: "running", Labels: map[string]string{LabelService: "db"}},
+	}, nil)
+	c.On("ContainerInspect", mock.Anything, "db-id").Once().Return(types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+		State: &types.ContainerState{Running: true, Health: &types.Health{Status: types.Healthy}},
+	}}, nil)
+
+	d, _ := NewClient(WithContainerClient(c), WithImageClient(&mocks.ImageAPIClient{}), WithNetworkClient(&mocks.NetworkAPIClient{}))
+	statuses, err := d.StackStatus(context.Background(), testStack())
+	assert.NoError(t, err)
+	assert.Equal(t, []ServiceStatus{
+		{Service: "db", ContainerID: "db-id", Container: "ankor_backend_db", State: "running", Health: types.Healthy},
+		{Service: "app", State: ServiceMissing},
+	}, statuses)
+}
@@ -0,0 +1,90 @@
+package docker
+
+import (
+	"io"
+	"os"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/go-errors/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// Streams are the standard streams attached to a container or an exec.
+type Streams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// ExitLogLines is how many trailing output lines are kept on the error
+	// returned for a non-zero exit code.
+	ExitLogLines *int
+}
+
+func (s *Streams) stdout() io.Writer {
+	if s.Stdout == nil {
+		return os.Stdout
+	}
+	return s.Stdout
+}
+
+func (s *Streams) stderr() io.Writer {
+	if s.Stderr == nil {
+		return os.Stderr
+	}
+	return s.Stderr
+}
+
+func (s *Streams) exitLogLines() int {
+	if s.ExitLogLines == nil {
+		return DefaultExitLogLines
+	}
+	return *s.ExitLogLines
+}
+
+// attachment is the output of an attached container or exec being streamed.
+type attachment struct {
+	// done receives the result of the copy once the daemon closes the stream
+	done <-chan error
+	tail *tailBuffer
+	// restore resets the terminal put into raw mode for a TTY
+	restore func()
+}
+
+// stream copies the output of an attached container or exec to the streams,
+// keeping its trailing lines, and forwards stdin to it.
+func (s *Streams) stream(resp types.HijackedResponse, tty bool) (*attachment, error) {
+	tail := newTailBuffer(s.exitLogLines())
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		if tty {
+			// a TTY merges stdout and stderr into a single raw stream
+			_, err = io.Copy(io.MultiWriter(s.stdout(), tail), resp.Reader)
+		} else {
+			_, err = stdcopy.StdCopy(
+				io.MultiWriter(s.stdout(), tail),
+				io.MultiWriter(s.stderr(), tail),
+				resp.Reader)
+		}
+		done <- err
+	}()
+
+	a := &attachment{done: done, tail: tail, restore: func() {}}
+	if s.Stdin == nil {
+		return a, nil
+	}
+	if tty {
+		restore, err := setRawTerminal(s.Stdin)
+		if err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+		a.restore = restore
+	}
+	go func() {
+		if _, err := io.Copy(resp.Conn, s.Stdin); err != nil {
+			log.Debug().Err(err).Msg("Error forwarding stdin")
+		}
+		_ = resp.CloseWrite()
+	}()
+	return a, nil
+}
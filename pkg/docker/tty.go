@@ -0,0 +1,53 @@
+package docker
+
+import (
+	"context"
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/moby/term"
+	"github.com/rs/zerolog/log"
+)
+
+// setRawTerminal puts the terminal behind in into raw mode so keystrokes are
+// forwarded to the container unprocessed. The returned function restores the
+// previous terminal state and is a no-op when in is not a terminal.
+func setRawTerminal(in io.Reader) (func(), error) {
+	fd, isTerminal := term.GetFdInfo(in)
+	if !isTerminal {
+		return func() {}, nil
+	}
+	state, err := term.SetRawTerminal(fd)
+	if err != nil {
+		return func() {}, err
+	}
+	return func() {
+		if err := term.RestoreTerminal(fd, state); err != nil {
+			log.Debug().Err(err).Msg("Could not restore terminal state")
+		}
+	}, nil
+}
+
+// resizeFunc resizes the TTY of a container or exec, as implemented by
+// ContainerResize and ContainerExecResize.
+type resizeFunc func(ctx context.Context, id string, options types.ResizeOptions) error
+
+// resizeTTY sets the TTY of a container or exec to the size of the terminal
+// behind out.
+func (c *Client) resizeTTY(ctx context.Context, resize resizeFunc, id string, out io.Writer) {
+	fd, isTerminal := term.GetFdInfo(out)
+	if !isTerminal {
+		return
+	}
+	size, err := term.GetWinsize(fd)
+	if err != nil || size.Height == 0 || size.Width == 0 {
+		return
+	}
+	err = resize(ctx, id, types.ResizeOptions{
+		Height: uint(size.Height),
+		Width:  uint(size.Width),
+	})
+	if err != nil {
+		log.Debug().Err(err).Msgf("Could not resize TTY of %s", id)
+	}
+}
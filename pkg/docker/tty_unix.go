@@ -0,0 +1,31 @@
+//go:build !windows
+
+package docker
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// monitorTTYSize resizes the TTY of a container or exec whenever the local
+// terminal behind out is resized, until ctx is done.
+func (c *Client) monitorTTYSize(ctx context.Context, resize resizeFunc, id string, out io.Writer) {
+	c.resizeTTY(ctx, resize, id, out)
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGWINCH)
+	go func() {
+		defer signal.Stop(sigchan)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sigchan:
+				c.resizeTTY(ctx, resize, id, out)
+			}
+		}
+	}()
+}
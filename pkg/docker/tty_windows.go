@@ -0,0 +1,39 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/moby/term"
+)
+
+// monitorTTYSize resizes the TTY of a container or exec whenever the local
+// terminal behind out is resized, until ctx is done. Windows has no SIGWINCH
+// so the console size is polled instead.
+func (c *Client) monitorTTYSize(ctx context.Context, resize resizeFunc, id string, out io.Writer) {
+	c.resizeTTY(ctx, resize, id, out)
+
+	fd, isTerminal := term.GetFdInfo(out)
+	if !isTerminal {
+		return
+	}
+	go func() {
+		prev, _ := term.GetWinsize(fd)
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				size, err := term.GetWinsize(fd)
+				if err != nil || (prev != nil && *size == *prev) {
+					continue
+				}
+				prev = size
+				c.resizeTTY(ctx, resize, id, out)
+			}
+		}
+	}()
+}
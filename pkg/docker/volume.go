@@ -0,0 +1,74 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/go-errors/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrNotFound is returned when inspecting a volume or network that does not
+// exist.
+var ErrNotFound = errors.New("does not exist")
+
+// CreateVolume creates a named volume with the supplied labels together
+// with the ankor labels. Creating a volume that already exists returns it.
+func (c *Client) CreateVolume(ctx context.Context, name string, labels map[string]string) (types.Volume, error) {
+	if err := c.connect(); err != nil {
+		return types.Volume{}, err
+	}
+	log.Debug().Msgf("Creating volume %s", name)
+	volume, err := c.volumes.VolumeCreate(ctx, volumetypes.VolumeCreateBody{
+		Name:   name,
+		Labels: c.withLabels(labels),
+	})
+	if err != nil {
+		return volume, errors.Wrap(err, 0)
+	}
+	return volume, nil
+}
+
+// InspectVolume returns the named volume, or ErrNotFound.
+func (c *Client) InspectVolume(ctx context.Context, name string) (types.Volume, error) {
+	if err := c.connect(); err != nil {
+		return types.Volume{}, err
+	}
+	volume, err := c.volumes.VolumeInspect(ctx, name)
+	if client.IsErrNotFound(err) {
+		return volume, errors.New(fmt.Errorf("volume '%s' %w", name, ErrNotFound))
+	}
+	if err != nil {
+		return volume, errors.Wrap(err, 0)
+	}
+	return volume, nil
+}
+
+// RemoveVolume removes the named volume, which must not be used by a
+// container unless force is set. Removing a missing volume succeeds.
+func (c *Client) RemoveVolume(ctx context.Context, name string, force bool) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+	log.Debug().Msgf("Removing volume %s", name)
+	if err := c.volumes.VolumeRemove(ctx, name, force); err != nil && !client.IsErrNotFound(err) {
+		return errors.Wrap(err, 0)
+	}
+	return nil
+}
+
+// ListVolumes returns the volumes created by ankor.
+func (c *Client) ListVolumes(ctx context.Context) ([]*types.Volume, error) {
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	list, err := c.volumes.VolumeList(ctx, filters.NewArgs(filters.Arg("label", LabelManaged+"=true")))
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+	return list.Volumes, nil
+}
@@ -0,0 +1,83 @@
+package docker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ankorstore/ankorstore-cli-modules/pkg/docker/mocks"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+	"github.com/go-errors/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func volumeClient(v *mocks.VolumeAPIClient) *Client {
+	d, _ := NewClient(
+		WithContainerClient(&mocks.ContainerAPIClient{}),
+		WithImageClient(&mocks.ImageAPIClient{}),
+		WithVolumeClient(v),
+		WithVersion("1.2.3"))
+	return d
+}
+
+func TestVolumes(t *testing.T) {
+	t.Run("creates volumes labelled as ankor-owned", func(t *testing.T) {
+		v := &mocks.VolumeAPIClient{}
+		v.On("VolumeCreate", mock.Anything, mock.MatchedBy(func(body volumetypes.VolumeCreateBody) bool {
+			return body.Name == "ankor-go-mod" &&
+				body.Labels[LabelCache] == "true" &&
+				body.Labels[LabelManaged] == "true" &&
+				body.Labels[LabelVersion] == "1.2.3"
+		})).
+			Once().
+			Return(types.Volume{Name: "ankor-go-mod"}, nil)
+
+		volume, err := volumeClient(v).CreateVolume(context.Background(), "ankor-go-mod", map[string]string{LabelCache: "true"})
+		assert.NoError(t, err)
+		assert.Equal(t, "ankor-go-mod", volume.Name)
+		v.AssertExpectations(t)
+	})
+
+	t.Run("inspects volumes", func(t *testing.T) {
+		v := &mocks.VolumeAPIClient{}
+		v.On("VolumeInspect", mock.Anything, "ankor-go-mod").Once().Return(types.Volume{Name: "ankor-go-mod", Mountpoint: "/var/lib/docker/volumes/ankor-go-mod/_data"}, nil)
+		v.On("VolumeInspect", mock.Anything, "missing").Once().Return(types.Volume{}, errdefs.NotFound(errors.New("no such volume")))
+
+		d := volumeClient(v)
+		volume, err := d.InspectVolume(context.Background(), "ankor-go-mod")
+		assert.NoError(t, err)
+		assert.Equal(t, "/var/lib/docker/volumes/ankor-go-mod/_data", volume.Mountpoint)
+
+		_, err = d.InspectVolume(context.Background(), "missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.Equal(t, "volume 'missing' does not exist", err.Error())
+	})
+
+	t.Run("removes volumes", func(t *testing.T) {
+		v := &mocks.VolumeAPIClient{}
+		v.On("VolumeRemove", mock.Anything, "ankor-go-mod", true).Once().Return(nil)
+		v.On("VolumeRemove", mock.Anything, "missing", false).Once().Return(errdefs.NotFound(errors.New("no such volume")))
+		v.On("VolumeRemove", mock.Anything, "in-use", false).Once().Return(errdefs.Conflict(errors.New("volume is in use")))
+
+		d := volumeClient(v)
+		assert.NoError(t, d.RemoveVolume(context.Background(), "ankor-go-mod", true))
+		assert.NoError(t, d.RemoveVolume(context.Background(), "missing", false))
+		assert.ErrorContains(t, d.RemoveVolume(context.Background(), "in-use", false), "volume is in use")
+		v.AssertExpectations(t)
+	})
+
+	t.Run("lists ankor volumes", func(t *testing.T) {
+		v := &mocks.VolumeAPIClient{}
+		v.On("VolumeList", mock.Anything, filters.NewArgs(filters.Arg("label", LabelManaged+"=true"))).
+			Once().
+			Return(volumetypes.VolumeListOKBody{Volumes: []*types.Volume{{Name: "ankor-go-mod"}, {Name: "ankor-npm"}}}, nil)
+
+		volumes, err := volumeClient(v).ListVolumes(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, volumes, 2)
+		assert.Equal(t, "ankor-npm", volumes[1].Name)
+	})
+}